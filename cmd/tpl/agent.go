@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/spf13/pflag"
+	"github.com/zerok/tpl/internal/world"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const defaultAgentInterval = 5 * time.Minute
+
+// minAgentInterval prevents secrets with very short leases from causing a
+// busy loop.
+const minAgentInterval = time.Second
+
+// agentConfig is loaded from the file passed to `tpl agent --config`.
+type agentConfig struct {
+	// Interval between two renders (e.g. 5m). Renders happen earlier if a
+	// secret's lease expires before.
+	Interval  string          `yaml:"interval"`
+	Templates []agentTemplate `yaml:"templates"`
+}
+
+// agentTemplate describes a single template rendered by the agent. Relative
+// paths are resolved against the directory of the configuration file.
+type agentTemplate struct {
+	Source      string `yaml:"source"`
+	Destination string `yaml:"destination"`
+	Mode        string `yaml:"mode"`
+	Owner       string `yaml:"owner"`
+	Group       string `yaml:"group"`
+	KeepMode    bool   `yaml:"keep_mode"`
+
+	// Command is executed using bash whenever the destination has changed.
+	Command string `yaml:"command"`
+
+	// Signal is sent to the process identified by PID or PIDFile whenever
+	// the destination has changed.
+	Signal  string `yaml:"signal"`
+	PID     int    `yaml:"pid"`
+	PIDFile string `yaml:"pid_file"`
+
+	file fileOptions
+}
+
+func loadAgentConfig(path string) (*agentConfig, time.Duration, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to read %s", path)
+	}
+	var cfg agentConfig
+	if err := yaml.UnmarshalStrict(raw, &cfg); err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to parse %s", path)
+	}
+	interval := defaultAgentInterval
+	if cfg.Interval != "" {
+		interval, err = time.ParseDuration(cfg.Interval)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "invalid interval `%s`", cfg.Interval)
+		}
+		if interval < minAgentInterval {
+			return nil, 0, errors.Errorf("interval has to be at least %s", minAgentInterval)
+		}
+	}
+	if len(cfg.Templates) == 0 {
+		return nil, 0, errors.Errorf("%s contains no templates", path)
+	}
+	dir := filepath.Dir(path)
+	for i := range cfg.Templates {
+		t := &cfg.Templates[i]
+		if t.Source == "" || t.Destination == "" {
+			return nil, 0, errors.Errorf("template #%d requires a source and a destination", i+1)
+		}
+		t.Source = resolvePath(dir, t.Source)
+		t.Destination = resolvePath(dir, t.Destination)
+		if t.PIDFile != "" {
+			t.PIDFile = resolvePath(dir, t.PIDFile)
+		}
+		if t.Signal != "" {
+			if _, ok := signals[strings.TrimPrefix(strings.ToUpper(t.Signal), "SIG")]; !ok {
+				return nil, 0, errors.Errorf("unsupported signal `%s` for %s", t.Signal, t.Source)
+			}
+			if t.PID == 0 && t.PIDFile == "" {
+				return nil, 0, errors.Errorf("signal for %s requires a pid or pid_file", t.Source)
+			}
+		}
+		if t.file, err = parseFileOptions(t.Mode, t.Owner, t.Group, t.KeepMode); err != nil {
+			return nil, 0, errors.Wrapf(err, "invalid file options for %s", t.Source)
+		}
+	}
+	return &cfg, interval, nil
+}
+
+func resolvePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// runAgent implements `tpl agent`: all the templates of the configuration
+// are rendered periodically until SIGTERM or SIGINT is received.
+func runAgent(args []string) {
+	logger := newLogger()
+	var cfg worldConfig
+	var configFile string
+	var verbose bool
+	var once bool
+	flags := pflag.NewFlagSet("agent", pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print("Usage: tpl agent [options] --config=FILE\n\n")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&configFile, "config", "", "Agent configuration file listing templates and their destinations")
+	flags.BoolVar(&once, "once", false, "Render all templates once and exit")
+	flags.BoolVar(&verbose, "verbose", false, "Verbose log output")
+	cfg.registerFlags(flags)
+	flags.Parse(args)
+
+	if verbose {
+		logger = logger.Level(zerolog.DebugLevel)
+	}
+	if configFile == "" {
+		logger.Error().Msg("No agent configuration provided")
+		flags.Usage()
+		os.Exit(1)
+	}
+	agentCfg, interval, err := loadAgentConfig(configFile)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to load agent configuration")
+	}
+	if cfg.secretCache != "" {
+		// Cached values would hide rotated secrets until they expire.
+		logger.Warn().Msg("--secret-cache is ignored in agent mode")
+		cfg.secretCache = ""
+	}
+
+	ctx, cancel := context.WithCancel(logger.WithContext(context.Background()))
+	defer cancel()
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-stop
+		logger.Info().Msgf("Received %s, shutting down", sig)
+		cancel()
+	}()
+
+	// The world and with it the authenticated secret backends are kept
+	// across renders so that Vault tokens are renewed instead of logging
+	// in again every time.
+	var w *world.World
+	for {
+		wait := interval
+		if w == nil {
+			if w, err = cfg.newBaseWorld(ctx); err != nil {
+				logger.Error().Err(err).Msg("Failed to set up rendering")
+			}
+		}
+		ok, ttl := runAgentOnce(ctx, w, &cfg, agentCfg)
+		if once {
+			if !ok {
+				os.Exit(1)
+			}
+			return
+		}
+		// Render again before the leases of the secrets run out.
+		if ttl > 0 && ttl*2/3 < wait {
+			wait = ttl * 2 / 3
+		}
+		if wait < minAgentInterval {
+			wait = minAgentInterval
+		}
+		logger.Debug().Msgf("Next render in %s", wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// runAgentOnce renders all templates using the given world so that every
+// secret is only retrieved once. The data is reloaded and the secrets
+// retrieved by previous renders are forgotten beforehand. Destinations are
+// only written and their commands only executed if the content has changed.
+// Errors are logged and leave the previous output in place. It returns if
+// all templates were rendered successfully and the shortest lifetime of the
+// secrets used.
+func runAgentOnce(ctx context.Context, w *world.World, cfg *worldConfig, agentCfg *agentConfig) (bool, time.Duration) {
+	if w == nil {
+		return false, 0
+	}
+	logger := zerolog.Ctx(ctx)
+	w.ResetSecrets()
+	if err := cfg.loadData(ctx, w); err != nil {
+		logger.Error().Err(err).Msg("Failed to set up rendering")
+		return false, 0
+	}
+	success := true
+	for _, t := range agentCfg.Templates {
+		if ctx.Err() != nil {
+			break
+		}
+		changed, err := renderAgentTemplate(w, &t)
+		if err != nil {
+			logger.Error().Err(err).Msgf("Failed to render %s", t.Source)
+			success = false
+			continue
+		}
+		if !changed {
+			logger.Debug().Msgf("%s is up to date", t.Destination)
+			continue
+		}
+		logger.Info().Msgf("Rendered %s to %s", t.Source, t.Destination)
+		if err := notifyAgentTemplate(ctx, &t); err != nil {
+			logger.Error().Err(err).Msgf("Failed to notify about changes of %s", t.Destination)
+			success = false
+		}
+	}
+	return success, w.SecretTTL()
+}
+
+func renderAgentTemplate(w *world.World, t *agentTemplate) (bool, error) {
+	fp, err := os.Open(t.Source)
+	if err != nil {
+		return false, err
+	}
+	defer fp.Close()
+	var output bytes.Buffer
+	if err := w.RenderTemplate(&output, fp, t.Source); err != nil {
+		return false, err
+	}
+	return writeFile(t.Destination, output.Bytes(), 0600, t.file)
+}
+
+// notifyAgentTemplate runs the command and sends the signal configured for
+// the template.
+func notifyAgentTemplate(ctx context.Context, t *agentTemplate) error {
+	logger := zerolog.Ctx(ctx)
+	if t.Command != "" {
+		logger.Info().Msgf("Running `%s`", t.Command)
+		cmd := exec.Command("/bin/bash", "-c", t.Command)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return errors.Wrapf(err, "command `%s` failed", t.Command)
+		}
+	}
+	if t.Signal != "" {
+		pid := t.PID
+		if t.PIDFile != "" {
+			raw, err := ioutil.ReadFile(t.PIDFile)
+			if err != nil {
+				return errors.Wrapf(err, "failed to read pid file")
+			}
+			pid, err = strconv.Atoi(strings.TrimSpace(string(raw)))
+			if err != nil {
+				return errors.Wrapf(err, "invalid pid in %s", t.PIDFile)
+			}
+		}
+		proc, err := os.FindProcess(pid)
+		if err != nil {
+			return errors.Wrapf(err, "failed to find process %d", pid)
+		}
+		sig := signals[strings.TrimPrefix(strings.ToUpper(t.Signal), "SIG")]
+		logger.Info().Msgf("Sending %s to process %d", sig, pid)
+		if err := proc.Signal(sig); err != nil {
+			return errors.Wrapf(err, "failed to send %s to process %d", sig, pid)
+		}
+	}
+	return nil
+}
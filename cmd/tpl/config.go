@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/zerok/tpl/internal/world"
+)
+
+// worldConfig contains all the settings that are needed to set up a World
+// including its data.
+type worldConfig struct {
+	leftDelim       string
+	rightDelim      string
+	insecure        bool
+	strict          bool
+	vaultPrefix     string
+	vaultMapping    string
+	vaultAuth       string
+	vaultAuthMount  string
+	azurePrefix     string
+	azureMapping    string
+	awsPrefix       string
+	awsMapping      string
+	data            []string
+	dataRoot        []string
+	dataListMerge   string
+	setValues       []string
+	setStringValues []string
+	setFileValues   []string
+	includeDirs     []string
+	partials        []string
+	ageIdentities   []string
+	envFiles        []string
+	envFileOnly     bool
+	secretCache     string
+	secretCacheTTL  time.Duration
+}
+
+func (c *worldConfig) registerFlags(flags *pflag.FlagSet) {
+	c.registerTemplateFlags(flags)
+	flags.StringVar(&c.vaultPrefix, "vault-prefix", "", "Prefix for all Vault paths")
+	flags.StringVar(&c.vaultMapping, "vault-mapping", "", "Key mapping file for Vault keys")
+	flags.StringVar(&c.vaultAuth, "vault-auth", "", "Vault auth method (token, token-file, approle, userpass, kubernetes, jwt)")
+	flags.StringVar(&c.vaultAuthMount, "vault-auth-mount", "", "Path the Vault auth method is mounted at (defaults to the method name)")
+	flags.BoolVar(&c.insecure, "insecure", false, "Enables features like shell output")
+	flags.StringArrayVar(&c.data, "data", []string{}, "Data definitions (e.g. --data=name=file.yaml, --data=name=-:json or --data=name=https://host/file.yaml)")
+	flags.StringArrayVar(&c.dataRoot, "data-root", []string{}, "Data files merged directly into .Data (e.g. --data-root=values.yaml)")
+	flags.StringVar(&c.dataListMerge, "data-list-merge", world.ListMergeReplace, "How lists are merged if a data key is defined multiple times (replace or append)")
+	flags.StringArrayVar(&c.setValues, "set", []string{}, "Set a data value (e.g. --set db.host=10.0.0.1 or --set servers[0].name=web)")
+	flags.StringArrayVar(&c.setStringValues, "set-string", []string{}, "Set a data value without type inference (e.g. --set-string tag=0123)")
+	flags.StringArrayVar(&c.setFileValues, "set-file", []string{}, "Set a data value to the content of a file (e.g. --set-file cert=./ca.pem)")
+	flags.StringSliceVar(&c.ageIdentities, "age-identity", []string{}, "File with age identities used to decrypt SOPS and age encrypted data files")
+	flags.StringArrayVar(&c.envFiles, "env-file", []string{}, "File with environment variables in dotenv syntax layered over the process environment (repeatable)")
+	flags.BoolVar(&c.envFileOnly, "env-file-only", false, "Ignore the process environment and only use the variables from --env-file")
+	flags.StringVar(&c.secretCache, "secret-cache", "", "Directory secrets are cached in (encrypted) across runs (e.g. --secret-cache=~/.cache/tpl)")
+	flags.DurationVar(&c.secretCacheTTL, "secret-cache-ttl", world.DefaultSecretCacheTTL, "How long secrets are served from --secret-cache")
+	flags.StringVar(&c.azurePrefix, "azure-prefix", "", "Prefix for all Azure keyvault paths")
+	flags.StringVar(&c.azureMapping, "azure-mapping", "", "Key mapping file for Azure keyvault keys")
+	flags.StringVar(&c.awsPrefix, "aws-prefix", "", "Prefix for all AWS Secrets Manager and SSM paths")
+	flags.StringVar(&c.awsMapping, "aws-mapping", "", "Key mapping file for AWS Secrets Manager and SSM keys")
+}
+
+// registerTemplateFlags registers the flags which affect how templates are
+// parsed and executed but not where their data comes from.
+func (c *worldConfig) registerTemplateFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&c.leftDelim, "left-delimiter", "{{", "Left delimiter used within the Go template system")
+	flags.StringVar(&c.rightDelim, "right-delimiter", "}}", "Right delimiter used within the Go template system")
+	flags.BoolVar(&c.strict, "strict", false, "Fail on missing map keys and unset environment variables instead of rendering empty values")
+	flags.StringSliceVar(&c.includeDirs, "include-dir", []string{}, "Directory whose files can be used as templates and includes (named relative to the directory)")
+	flags.StringSliceVar(&c.partials, "partials", []string{}, "Glob pattern of files parsed together with the main template (e.g. --partials='partials/*.tpl')")
+}
+
+func (c *worldConfig) dataOptions() *world.DataOptions {
+	return &world.DataOptions{
+		AgeIdentities: c.ageIdentities,
+		Insecure:      c.insecure,
+		Root:          c.dataRoot,
+		ListMerge:     c.dataListMerge,
+		Set:           c.setValues,
+		SetString:     c.setStringValues,
+		SetFile:       c.setFileValues,
+	}
+}
+
+// newWorld creates a world with all secret backends configured and the data
+// loaded.
+func (c *worldConfig) newWorld(ctx context.Context) (*world.World, error) {
+	w, err := c.newBaseWorld(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.loadData(ctx, w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// loadData (re)loads the data of the given world.
+func (c *worldConfig) loadData(ctx context.Context, w *world.World) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return errors.Wrap(err, "failed to determine current working directory")
+	}
+	dataOpts := c.dataOptions()
+	dataOpts.LookupEnv = func(name string) (string, bool) {
+		value, ok := w.Env()[name]
+		return value, ok
+	}
+	d, err := world.LoadDataWithOptions(ctx, c.data, wd, dataOpts)
+	if err != nil {
+		return errors.Wrap(err, "failed to load data")
+	}
+	w.Data = d
+	return nil
+}
+
+// newBaseWorld creates a world with all secret backends configured but
+// without loading any data.
+func (c *worldConfig) newBaseWorld(ctx context.Context) (*world.World, error) {
+	lookup := os.LookupEnv
+	if c.envFileOnly {
+		lookup = nil
+	}
+	env, err := world.LoadEnvFiles(c.envFiles, lookup)
+	if err != nil {
+		return nil, err
+	}
+	secretCache, err := expandHome(c.secretCache)
+	if err != nil {
+		return nil, err
+	}
+	w := world.New(ctx, &world.Options{
+		Insecure:       c.insecure,
+		LeftDelim:      c.leftDelim,
+		RightDelim:     c.rightDelim,
+		VaultAuth:      c.vaultAuth,
+		VaultAuthMount: c.vaultAuthMount,
+		Partials:       c.partials,
+		IncludeDirs:    c.includeDirs,
+		Strict:         c.strict,
+		Env:            env,
+		EnvOnly:        c.envFileOnly,
+		SecretCache:    secretCache,
+		SecretCacheTTL: c.secretCacheTTL,
+		LogOutput:      newLogOutput(),
+	})
+	pathMappings := []struct {
+		name    string
+		prefix  string
+		file    string
+		mapping func() *world.PathMapping
+	}{
+		{"vault", c.vaultPrefix, c.vaultMapping, func() *world.PathMapping { return &w.Vault().PathMapping }},
+		{"azure", c.azurePrefix, c.azureMapping, func() *world.PathMapping { return &w.Azure().PathMapping }},
+		{"aws", c.awsPrefix, c.awsMapping, func() *world.PathMapping { return &w.AWS().PathMapping }},
+	}
+	for _, pm := range pathMappings {
+		if pm.prefix != "" {
+			pm.mapping().Prefix = pm.prefix
+		}
+		if pm.file != "" {
+			keyMap, err := loadKeyMapping(pm.file)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to load %s mapping file", pm.name)
+			}
+			pm.mapping().KeyMapping = keyMap
+		}
+	}
+	return w, nil
+}
+
+// expandHome replaces a leading ~/ with the home directory of the current
+// user.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to determine home directory")
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// files returns the absolute paths of all the local files the configuration
+// depends on: data files, env files, mapping files and age identities.
+func (c *worldConfig) files() []string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil
+	}
+	result := world.DataFiles(c.data, wd, c.dataOptions())
+	paths := append([]string{c.vaultMapping, c.azureMapping, c.awsMapping}, c.ageIdentities...)
+	for _, path := range append(paths, c.envFiles...) {
+		if path == "" {
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(wd, path)
+		}
+		result = append(result, path)
+	}
+	return result
+}
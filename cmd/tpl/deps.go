@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/pflag"
+	"github.com/zerok/tpl/internal/world"
+)
+
+// runDeps implements `tpl deps`: the template is parsed but not executed and
+// everything it refers to is listed.
+func runDeps(args []string) {
+	var cfg worldConfig
+	var format string
+	var verbose bool
+	flags := pflag.NewFlagSet("deps", pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print("Usage: tpl deps [options] template-file\n\n")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&format, "format", "text", "Output format (text or json)")
+	flags.BoolVar(&verbose, "verbose", false, "Verbose log output")
+	cfg.registerFlags(flags)
+	flags.Parse(args)
+
+	logger := newToolLogger(verbose)
+	if format != "text" && format != "json" {
+		logger.Fatal().Msgf("Unsupported format `%s`", format)
+	}
+	input := flags.Arg(0)
+	if input == "" {
+		logger.Error().Msg("No input file provided")
+		flags.Usage()
+		os.Exit(1)
+	}
+	ctx := logger.WithContext(context.Background())
+	w, err := cfg.newBaseWorld(ctx)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to set up world")
+	}
+	var rd io.Reader = os.Stdin
+	var path string
+	if input != "-" {
+		fp, err := os.Open(input)
+		if err != nil {
+			logger.Fatal().Err(err).Msgf("Failed to open template %s", input)
+		}
+		defer fp.Close()
+		rd = fp
+		path = input
+	}
+	deps, err := w.Dependencies(rd, path)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to parse template")
+	}
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(deps); err != nil {
+			logger.Fatal().Err(err).Msg("Failed to write dependencies")
+		}
+		return
+	}
+	printDependencies(os.Stdout, deps)
+}
+
+// printDependencies writes one dependency per line prefixed with its kind.
+// Dependencies that cannot be resolved without rendering are marked as
+// dynamic together with the expression and its location.
+func printDependencies(out io.Writer, deps *world.Dependencies) {
+	for _, s := range deps.Secrets {
+		if s.Dynamic {
+			backend := s.Backend
+			if backend == "" {
+				backend = "?"
+			}
+			fmt.Fprintf(out, "secret   %s://<dynamic> `%s` at %s\n", backend, s.Expression, s.Location)
+			continue
+		}
+		ref := world.SecretRef{Path: s.Path, Field: s.Field, Version: s.Version}
+		line := fmt.Sprintf("secret   %s://%s", s.Backend, ref.String())
+		if s.ResolvedPath != s.Path {
+			line += fmt.Sprintf(" (resolved: %s)", s.ResolvedPath)
+		}
+		fmt.Fprintln(out, line)
+	}
+	for _, list := range []struct {
+		kind string
+		deps []world.Dependency
+	}{
+		{"env", deps.Env},
+		{"data", deps.Data},
+		{"file", deps.Files},
+		{"command", deps.Commands},
+	} {
+		for _, dep := range list.deps {
+			if dep.Dynamic {
+				fmt.Fprintf(out, "%-8s <dynamic> `%s` at %s\n", list.kind, dep.Expression, dep.Location)
+				continue
+			}
+			fmt.Fprintf(out, "%-8s %s\n", list.kind, dep.Name)
+		}
+	}
+}
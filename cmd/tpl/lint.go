@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/zerok/tpl/internal/world"
+)
+
+// runLint implements `tpl lint`: the templates are checked for problems
+// without rendering them. The exit code is 1 if any error was found.
+func runLint(args []string) {
+	var cfg worldConfig
+	var format string
+	var verbose bool
+	flags := pflag.NewFlagSet("lint", pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print("Usage: tpl lint [options] template-file...\n\n")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&format, "format", "text", "Output format (text, github or json)")
+	flags.BoolVar(&verbose, "verbose", false, "Verbose log output")
+	cfg.registerFlags(flags)
+	flags.Parse(args)
+
+	logger := newToolLogger(verbose)
+	if format != "text" && format != "github" && format != "json" {
+		logger.Fatal().Msgf("Unsupported format `%s`", format)
+	}
+	if flags.NArg() == 0 {
+		logger.Error().Msg("No input file provided")
+		flags.Usage()
+		os.Exit(1)
+	}
+	ctx := logger.WithContext(context.Background())
+	opts := &world.LintOptions{
+		CheckData: len(cfg.data)+len(cfg.dataRoot)+len(cfg.setValues)+len(cfg.setStringValues)+len(cfg.setFileValues) > 0,
+	}
+	// Loading the data may run commands or fetch URLs. It is therefore only
+	// done if the data is actually checked.
+	newWorld := cfg.newBaseWorld
+	if opts.CheckData {
+		newWorld = cfg.newWorld
+	}
+	w, err := newWorld(ctx)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to set up world")
+	}
+
+	issues := make([]world.LintIssue, 0)
+	for _, input := range flags.Args() {
+		found, err := lintFile(w, input, opts)
+		if err != nil {
+			logger.Fatal().Err(err).Msgf("Failed to lint %s", input)
+		}
+		issues = append(issues, found...)
+	}
+	// Partials are checked together with every template.
+	issues = uniqueLintIssues(issues)
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(issues); err != nil {
+			logger.Fatal().Err(err).Msg("Failed to write issues")
+		}
+	} else {
+		printLintIssues(os.Stdout, issues, format == "github")
+	}
+	for _, issue := range issues {
+		if issue.Severity == world.LintError {
+			os.Exit(1)
+		}
+	}
+}
+
+// lintFile lints the given template or stdin if input is -.
+func lintFile(w *world.World, input string, opts *world.LintOptions) ([]world.LintIssue, error) {
+	if input == "-" {
+		return w.Lint(os.Stdin, "", opts)
+	}
+	fp, err := os.Open(input)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	return w.Lint(fp, input, opts)
+}
+
+func uniqueLintIssues(issues []world.LintIssue) []world.LintIssue {
+	seen := make(map[world.LintIssue]struct{})
+	result := issues[:0]
+	for _, issue := range issues {
+		if _, ok := seen[issue]; ok {
+			continue
+		}
+		seen[issue] = struct{}{}
+		result = append(result, issue)
+	}
+	return result
+}
+
+// workflowDataEscaper and workflowPropertyEscaper escape the message and
+// the properties of workflow commands so that they can't end the command
+// early.
+var (
+	workflowDataEscaper     = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	workflowPropertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+// printLintIssues writes one issue per line either as file:line:column or
+// as workflow commands which GitHub Actions turns into annotations.
+func printLintIssues(out io.Writer, issues []world.LintIssue, github bool) {
+	for _, issue := range issues {
+		if github {
+			location := fmt.Sprintf("file=%s", workflowPropertyEscaper.Replace(issue.File))
+			if issue.Line > 0 {
+				location += fmt.Sprintf(",line=%d", issue.Line)
+			}
+			if issue.Column > 0 {
+				location += fmt.Sprintf(",col=%d", issue.Column)
+			}
+			title := workflowPropertyEscaper.Replace("tpl " + issue.Rule)
+			fmt.Fprintf(out, "::%s %s,title=%s::%s\n", issue.Severity, location, title, workflowDataEscaper.Replace(issue.Message))
+			continue
+		}
+		location := issue.File
+		if issue.Line > 0 {
+			location += fmt.Sprintf(":%d", issue.Line)
+		}
+		if issue.Column > 0 {
+			location += fmt.Sprintf(":%d", issue.Column)
+		}
+		fmt.Fprintf(out, "%s: %s: %s (%s)\n", location, issue.Severity, issue.Message, issue.Rule)
+	}
+}
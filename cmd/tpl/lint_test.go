@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zerok/tpl/internal/world"
+)
+
+func TestPrintLintIssues(t *testing.T) {
+	issues := []world.LintIssue{
+		{File: "config.tpl", Line: 2, Column: 5, Severity: world.LintError, Rule: world.LintRuleParse, Message: "unclosed action started at config.tpl:2"},
+		{File: "a,b:c.tpl", Line: 1, Severity: world.LintWarning, Rule: world.LintRuleMissingData, Message: "100% wrong\nsecond line\r"},
+	}
+
+	var out bytes.Buffer
+	printLintIssues(&out, issues, true)
+	require.Equal(t, `::error file=config.tpl,line=2,col=5,title=tpl parse::unclosed action started at config.tpl:2
+::warning file=a%2Cb%3Ac.tpl,line=1,title=tpl missing-data::100%25 wrong%0Asecond line%0D
+`, out.String())
+
+	out.Reset()
+	printLintIssues(&out, issues, false)
+	require.Equal(t, "config.tpl:2:5: error: unclosed action started at config.tpl:2 (parse)\na,b:c.tpl:1: warning: 100% wrong\nsecond line\r (missing-data)\n", out.String())
+}
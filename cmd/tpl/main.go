@@ -2,54 +2,74 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/csv"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/spf13/pflag"
-	"github.com/zerok/tpl/internal/world"
 )
 
 var version, commit, date string
 
 func main() {
-	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger().Level(zerolog.InfoLevel)
-	var input string
-	var vaultPrefix string
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "agent":
+			runAgent(os.Args[2:])
+			return
+		case "deps":
+			runDeps(os.Args[2:])
+			return
+		case "lint":
+			runLint(os.Args[2:])
+			return
+		case "test":
+			runTest(os.Args[2:])
+			return
+		}
+	}
+	logger := newLogger()
+	var cfg worldConfig
+	var t target
 	var showVersion bool
-	var vaultMapping string
 	var verbose bool
 	var showLicenseInfo bool
-	var leftDelim string
-	var rightDelim string
-	var insecure bool
-	var data []string
-	var azurePrefix string
-	var azureMapping string
-	var outputFile string
+	var check bool
+	var showDiff bool
+	var fileMode string
+	var fileOwner string
+	var fileGroup string
+	var keepMode bool
+	var watch bool
+	var redact bool
+	var auditFile string
 
 	pflag.Usage = func() {
-		fmt.Print("Usage: tpl [options] template-file\n\n")
+		fmt.Print("Usage: tpl [options] template-file\n       tpl [options] --input-dir=DIR --output-dir=DIR\n       tpl agent [options] --config=FILE\n       tpl deps [options] template-file\n       tpl lint [options] template-file...\n       tpl test [options] [path...]\n\n")
 		pflag.PrintDefaults()
 	}
 
-	pflag.StringVar(&outputFile, "output", "", "Output file")
-	pflag.StringVar(&vaultPrefix, "vault-prefix", "", "Prefix for all Vault paths")
-	pflag.StringVar(&vaultMapping, "vault-mapping", "", "Key mapping file for Vault keys")
+	pflag.StringVar(&t.outputFile, "output", "", "Output file")
+	pflag.StringVar(&t.inputDir, "input-dir", "", "Directory tree whose *.tpl files are rendered (all other files are copied)")
+	pflag.StringVar(&t.outputDir, "output-dir", "", "Directory the files from --input-dir are written to")
+	pflag.BoolVar(&check, "check", false, "Don't write anything but exit with an error if the output is not up to date")
+	pflag.BoolVar(&showDiff, "diff", false, "Don't write anything but print a diff (with secrets redacted) between the output and the rendered content")
+	pflag.BoolVar(&redact, "redact", false, "Replace secret values with *** in the rendered output")
+	pflag.StringVar(&auditFile, "audit", "", "Write a JSON report of all secrets, files, environment variables and commands accessed while rendering")
+	pflag.StringVar(&fileMode, "mode", "", "File mode of the output files (e.g. 0644, defaults to the mode of existing files, 0600 or the mode of the template within --input-dir)")
+	pflag.StringVar(&fileOwner, "owner", "", "User name or id output files are owned by")
+	pflag.StringVar(&fileGroup, "group", "", "Group name or id output files are owned by")
+	pflag.BoolVar(&keepMode, "keep-mode", false, "Only apply --mode, --owner and --group to new files and keep the mode and ownership of output files that already exist")
+	pflag.BoolVar(&watch, "watch", false, "Render again whenever the template, data files or files accessed by the template change")
 	pflag.BoolVar(&verbose, "verbose", false, "Verbose log output")
 	pflag.BoolVar(&showVersion, "version", false, "Show version information")
 	pflag.BoolVar(&showLicenseInfo, "licenses", false, "Show licenses of used libraries")
-	pflag.StringVar(&leftDelim, "left-delimiter", "{{", "Left delimiter used within the Go template system")
-	pflag.StringVar(&rightDelim, "right-delimiter", "}}", "Right delimiter used within the Go template system")
-	pflag.BoolVar(&insecure, "insecure", false, "Enables features like shell output")
-	pflag.StringSliceVar(&data, "data", []string{}, "Data definitions (e.g. --data=name=file.yaml)")
-	pflag.StringVar(&azurePrefix, "azure-prefix", "", "Prefix for all Azure keyvault paths")
-	pflag.StringVar(&azureMapping, "azure-mapping", "", "Key mapping file for Azure keyvault keys")
+	cfg.registerFlags(pflag.CommandLine)
 	pflag.Parse()
 
 	if verbose {
@@ -73,72 +93,102 @@ func main() {
 		os.Exit(0)
 	}
 
-	input = pflag.Arg(0)
-	if input == "" {
+	t.input = pflag.Arg(0)
+	if t.inputDir != "" {
+		if t.input != "" || t.outputFile != "" {
+			logger.Fatal().Msg("--input-dir cannot be combined with a template file or --output")
+		}
+		if t.outputDir == "" {
+			logger.Fatal().Msg("--output-dir is required when using --input-dir")
+		}
+	} else if t.outputDir != "" {
+		logger.Fatal().Msg("--output-dir can only be used together with --input-dir")
+	} else if t.input == "" {
 		logger.Error().Msg("No input file provided")
 		pflag.Usage()
 		os.Exit(1)
 	}
+	if t.input == "-" && (dataFromStdin(cfg.data) || dataFromStdin(cfg.dataRoot)) {
+		logger.Fatal().Msg("Template and data cannot both be read from stdin")
+	}
+	if (check || showDiff) && t.outputFile == "" && t.outputDir == "" {
+		logger.Fatal().Msg("--check and --diff require --output or --output-dir")
+	}
+	if watch && (check || showDiff || t.input == "-") {
+		logger.Fatal().Msg("--watch cannot be combined with --check, --diff or a template read from stdin")
+	}
+	fileOpts, err := parseFileOptions(fileMode, fileOwner, fileGroup, keepMode)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid output file options")
+	}
 
-	var rd io.Reader
-	if input == "-" {
-		rd = os.Stdin
-	} else {
-		fp, err := os.Open(input)
-		if err != nil {
-			logger.Fatal().Err(err).Msgf("Failed to open template %s", input)
+	out := &outputWriter{check: check, diff: showDiff, redact: redact, file: fileOpts, diffOut: os.Stdout, auditFile: auditFile}
+	if watch {
+		if err := watchTarget(ctx, &cfg, &t, out); err != nil {
+			logger.Fatal().Err(err).Msg("Failed to watch for changes")
 		}
-		defer fp.Close()
-		rd = fp
+		return
 	}
-
-	w := world.New(ctx, &world.Options{
-		Insecure:   insecure,
-		LeftDelim:  leftDelim,
-		RightDelim: rightDelim,
-	})
-	if vaultPrefix != "" {
-		w.Vault().Prefix = vaultPrefix
-		w.Azure().Prefix = azurePrefix
+	_, errs, auditErr := render(ctx, &cfg, &t, out)
+	if auditErr != nil {
+		logger.Error().Err(auditErr).Msg("Failed to write audit report")
 	}
-	if vaultMapping != "" {
-		vaultMap, err := loadKeyMapping(vaultMapping)
-		if err != nil {
-			logger.Fatal().Err(err).Msgf("Failed to load vault mapping file")
+	if t.inputDir == "" {
+		// Without --input-dir, there is at most a single render error.
+		for _, err := range errs {
+			logger.Fatal().Err(err).Msg("Failed to render")
 		}
-		azureMap, err := loadKeyMapping(azureMapping)
-		if err != nil {
-			logger.Fatal().Err(err).Msgf("Failed to load azure mapping file")
+	} else {
+		for _, err := range errs {
+			logger.Error().Err(err).Msg("Failed to render")
+		}
+		if len(errs) > 0 {
+			logger.Fatal().Msgf("Failed to render %d file(s) from %s", len(errs), t.inputDir)
 		}
-		w.Vault().KeyMapping = vaultMap
-		w.Azure().KeyMapping = azureMap
 	}
-	wd, err := os.Getwd()
-	if err != nil {
-		logger.Fatal().Err(err).Msg("Failed to determine current working directory.")
+	if auditErr != nil {
+		os.Exit(1)
 	}
-	d, err := world.LoadData(ctx, data, wd)
-	if err != nil {
-		logger.Fatal().Err(err).Msgf("Failed to load data")
+	if check && len(out.stale) > 0 {
+		for _, path := range out.stale {
+			logger.Error().Msgf("%s is not up to date", path)
+		}
+		os.Exit(1)
 	}
-	w.Data = d
+}
+
+func newLogger() zerolog.Logger {
+	return zerolog.New(newLogOutput()).With().Timestamp().Logger().Level(zerolog.InfoLevel)
+}
 
-	output := bytes.Buffer{}
-	if err := w.Render(&output, rd); err != nil {
-		logger.Fatal().Err(err).Msg("Failed to render")
+// newToolLogger returns the logger of the subcommands that inspect templates
+// without retrieving secrets. Unless verbose is set, only errors are logged
+// as the secret backends would otherwise complain about missing credentials
+// which don't matter there.
+func newToolLogger(verbose bool) zerolog.Logger {
+	if verbose {
+		return newLogger().Level(zerolog.DebugLevel)
 	}
-	if outputFile == "" {
-		io.Copy(os.Stdout, &output)
-	} else {
-		fp, err := os.OpenFile(outputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-		if err != nil {
-			logger.Fatal().Err(err).Msg("Failed to open output file")
-		}
-		defer fp.Close()
-		if _, err := io.Copy(fp, &output); err != nil {
-			logger.Fatal().Err(err).Msg("Failed to write to output file")
+	return newLogger().Level(zerolog.ErrorLevel)
+}
+
+// newLogOutput returns the writer log messages are written to. Worlds get
+// their own instance wrapped so that secret values are redacted.
+func newLogOutput() io.Writer {
+	return zerolog.ConsoleWriter{Out: os.Stderr}
+}
+
+// dataFromStdin checks if any of the given data definitions (or root data
+// sources) reads from stdin.
+func dataFromStdin(datadefs []string) bool {
+	for _, datadef := range datadefs {
+		elems := strings.SplitN(datadef, "=", 2)
+		source := elems[len(elems)-1]
+		if source == "-" || strings.HasPrefix(source, "-:") {
+			return true
 		}
 	}
+	return false
 }
 
 func loadKeyMapping(path string) (map[string]string, error) {
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/zerok/tpl/internal/world"
+)
+
+// fileOptions configures the permissions of written files.
+type fileOptions struct {
+	// mode overrides the default mode of a file if not 0.
+	mode os.FileMode
+
+	// keepMode keeps the mode and ownership of files that already exist
+	// even if mode, uid or gid are set. These only apply to new files then.
+	keepMode bool
+
+	// uid and gid change the ownership of the file if not -1.
+	uid int
+	gid int
+}
+
+// outputWriter handles the rendered content of a file. By default the
+// content is written to disk. In check or diff mode it is only compared to
+// what is already there.
+type outputWriter struct {
+	world  *world.World
+	check  bool
+	diff   bool
+	redact bool
+	file   fileOptions
+
+	// diffOut receives the unified diffs in diff mode.
+	diffOut io.Writer
+
+	// auditFile receives the audit report after every render if set.
+	auditFile string
+
+	// stale contains all the files whose content differs from the rendered
+	// one.
+	stale []string
+}
+
+// dryRun returns true if nothing should be written.
+func (o *outputWriter) dryRun() bool {
+	return o.check || o.diff
+}
+
+// content returns the rendered content with secrets replaced if requested
+// using --redact.
+func (o *outputWriter) content(rendered []byte) []byte {
+	if !o.redact {
+		return rendered
+	}
+	return []byte(o.world.Redact(string(rendered)))
+}
+
+// write handles the content of the file at path. New files are created
+// with mode unless it is overridden using --mode.
+func (o *outputWriter) write(path string, content []byte, mode os.FileMode) error {
+	return o.writeFile(path, content, mode, o.file)
+}
+
+// writeWithMode works like write but mode is also applied to existing files
+// unless --mode or --keep-mode are given. It is used to carry the modes of
+// the files in --input-dir over to the output.
+func (o *outputWriter) writeWithMode(path string, content []byte, mode os.FileMode) error {
+	opts := o.file
+	if opts.mode == 0 {
+		opts.mode = mode
+	}
+	return o.writeFile(path, content, mode, opts)
+}
+
+func (o *outputWriter) writeFile(path string, content []byte, mode os.FileMode, opts fileOptions) error {
+	content = o.content(content)
+	if !o.dryRun() {
+		_, err := writeFile(path, content, mode, opts)
+		return err
+	}
+	existing, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to read %s", path)
+	}
+	if bytes.Equal(existing, content) {
+		return nil
+	}
+	o.stale = append(o.stale, path)
+	if !o.diff {
+		return nil
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        diffLines(o.world.Redact(string(existing))),
+		B:        diffLines(o.world.Redact(string(content))),
+		FromFile: path,
+		ToFile:   path + " (rendered)",
+		Context:  3,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to diff %s", path)
+	}
+	_, err = io.WriteString(o.diffOut, diff)
+	return err
+}
+
+// writeAudit writes the audit report of the world as JSON.
+func (o *outputWriter) writeAudit(w *world.World) error {
+	report, err := json.MarshalIndent(w.AuditReport(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(o.auditFile, append(report, '\n'), 0600)
+}
+
+// diffLines splits the content into lines which all end with a newline
+// character as expected by difflib.
+func diffLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n"
+	return lines
+}
+
+// writeFile atomically replaces the file at path: the content is written
+// to a temporary file in the same directory which is then renamed. New files
+// are created with mode unless --mode is given. Existing files keep their
+// mode and ownership unless --mode, --owner or --group are given (and
+// --keep-mode isn't). If the file already has the given content, it is only
+// touched if permissions were requested explicitly so that its modification
+// time stays the same. The returned flag tells if the content has changed.
+func writeFile(path string, content []byte, mode os.FileMode, opts fileOptions) (bool, error) {
+	if opts.mode != 0 {
+		mode = opts.mode
+	}
+	uid, gid := opts.uid, opts.gid
+	// Replace the target of a symlink instead of the link itself.
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	info, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, errors.Wrapf(err, "failed to access %s", path)
+	}
+	// keepOwner is set if the ownership of the existing file is carried
+	// over without being requested. As only root can hand files over to
+	// other users, failing to do so is not an error.
+	var keepOwner bool
+	if info != nil {
+		explicit := !opts.keepMode && (opts.mode != 0 || uid != -1 || gid != -1)
+		if opts.keepMode || opts.mode == 0 {
+			mode = info.Mode().Perm()
+		}
+		if opts.keepMode || (uid == -1 && gid == -1) {
+			if ownerUID, ownerGID, ok := fileOwner(info); ok {
+				uid, gid = ownerUID, ownerGID
+				keepOwner = true
+			}
+		}
+		existing, err := ioutil.ReadFile(path)
+		if err == nil && bytes.Equal(existing, content) {
+			if !explicit {
+				return false, nil
+			}
+			return false, setPermissions(path, info, mode, uid, gid)
+		}
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to create temporary file for %s", path)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return false, errors.Wrapf(err, "failed to write %s", tmp.Name())
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return false, errors.Wrapf(err, "failed to write %s", tmp.Name())
+	}
+	if err := tmp.Close(); err != nil {
+		return false, errors.Wrapf(err, "failed to write %s", tmp.Name())
+	}
+	if err := setPermissions(tmp.Name(), nil, mode, -1, -1); err != nil {
+		return false, err
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(tmp.Name(), uid, gid); err != nil && !keepOwner {
+			return false, errors.Wrapf(err, "failed to change ownership of %s", path)
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return false, errors.Wrapf(err, "failed to replace %s", path)
+	}
+	return true, nil
+}
+
+// setPermissions updates the mode and ownership of path unless info shows
+// that they are already set.
+func setPermissions(path string, info os.FileInfo, mode os.FileMode, uid, gid int) error {
+	if info == nil || info.Mode().Perm() != mode {
+		if err := os.Chmod(path, mode); err != nil {
+			return errors.Wrapf(err, "failed to change mode of %s", path)
+		}
+	}
+	if uid == -1 && gid == -1 {
+		return nil
+	}
+	if info != nil {
+		if ownerUID, ownerGID, ok := fileOwner(info); ok && (uid == -1 || uid == ownerUID) && (gid == -1 || gid == ownerGID) {
+			return nil
+		}
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		return errors.Wrapf(err, "failed to change ownership of %s", path)
+	}
+	return nil
+}
+
+// parseFileOptions converts the values of --mode, --owner and --group.
+// Owner and group can either be names or numeric ids.
+func parseFileOptions(mode, owner, group string, keepMode bool) (fileOptions, error) {
+	opts := fileOptions{keepMode: keepMode, uid: -1, gid: -1}
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || m > 0777 {
+			return opts, errors.Errorf("invalid file mode `%s` (expected an octal value like 0644)", mode)
+		}
+		opts.mode = os.FileMode(m)
+	}
+	if owner != "" {
+		id, err := strconv.Atoi(owner)
+		if err != nil {
+			u, lookupErr := user.Lookup(owner)
+			if lookupErr != nil {
+				return opts, errors.Wrapf(lookupErr, "unknown owner `%s`", owner)
+			}
+			id, _ = strconv.Atoi(u.Uid)
+		}
+		opts.uid = id
+	}
+	if group != "" {
+		id, err := strconv.Atoi(group)
+		if err != nil {
+			g, lookupErr := user.LookupGroup(group)
+			if lookupErr != nil {
+				return opts, errors.Wrapf(lookupErr, "unknown group `%s`", group)
+			}
+			id, _ = strconv.Atoi(g.Gid)
+		}
+		opts.gid = id
+	}
+	return opts, nil
+}
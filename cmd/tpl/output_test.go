@@ -0,0 +1,158 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not supported on Windows")
+	}
+	defaults := fileOptions{uid: -1, gid: -1}
+	// existing creates a file with the given mode and a modification time
+	// in the past so that untouched files can be detected.
+	existing := func(t *testing.T, content string, mode os.FileMode) string {
+		path := filepath.Join(t.TempDir(), "out.conf")
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), mode))
+		require.NoError(t, os.Chmod(path, mode))
+		past := time.Now().Add(-time.Hour)
+		require.NoError(t, os.Chtimes(path, past, past))
+		return path
+	}
+	requireFile := func(t *testing.T, path, content string, mode os.FileMode) os.FileInfo {
+		raw, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, content, string(raw))
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, mode, info.Mode().Perm())
+		return info
+	}
+
+	t.Run("new", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.conf")
+		changed, err := writeFile(path, []byte("a"), 0600, defaults)
+		require.NoError(t, err)
+		require.True(t, changed)
+		requireFile(t, path, "a", 0600)
+
+		path = filepath.Join(t.TempDir(), "out.conf")
+		_, err = writeFile(path, []byte("a"), 0600, fileOptions{mode: 0640, uid: -1, gid: -1})
+		require.NoError(t, err)
+		requireFile(t, path, "a", 0640)
+
+		path = filepath.Join(t.TempDir(), "out.conf")
+		_, err = writeFile(path, []byte("a"), 0600, fileOptions{mode: 0640, keepMode: true, uid: -1, gid: -1})
+		require.NoError(t, err)
+		requireFile(t, path, "a", 0640)
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		path := existing(t, "a", 0644)
+		before, err := os.Stat(path)
+		require.NoError(t, err)
+		changed, err := writeFile(path, []byte("a"), 0600, defaults)
+		require.NoError(t, err)
+		require.False(t, changed)
+		info := requireFile(t, path, "a", 0644)
+		require.Equal(t, before.ModTime(), info.ModTime())
+
+		_, err = writeFile(path, []byte("a"), 0600, fileOptions{mode: 0640, keepMode: true, uid: -1, gid: -1})
+		require.NoError(t, err)
+		requireFile(t, path, "a", 0644)
+
+		changed, err = writeFile(path, []byte("a"), 0600, fileOptions{mode: 0640, uid: -1, gid: -1})
+		require.NoError(t, err)
+		require.False(t, changed)
+		info = requireFile(t, path, "a", 0640)
+		require.Equal(t, before.ModTime(), info.ModTime())
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		path := existing(t, "a", 0644)
+		changed, err := writeFile(path, []byte("b"), 0600, defaults)
+		require.NoError(t, err)
+		require.True(t, changed)
+		requireFile(t, path, "b", 0644)
+
+		_, err = writeFile(path, []byte("c"), 0600, fileOptions{mode: 0640, keepMode: true, uid: -1, gid: -1})
+		require.NoError(t, err)
+		requireFile(t, path, "c", 0644)
+
+		_, err = writeFile(path, []byte("d"), 0600, fileOptions{mode: 0640, uid: -1, gid: -1})
+		require.NoError(t, err)
+		requireFile(t, path, "d", 0640)
+
+		entries, err := ioutil.ReadDir(filepath.Dir(path))
+		require.NoError(t, err)
+		require.Len(t, entries, 1, "temporary files are removed")
+	})
+
+	t.Run("symlink", func(t *testing.T) {
+		target := existing(t, "a", 0644)
+		path := filepath.Join(t.TempDir(), "link.conf")
+		require.NoError(t, os.Symlink(target, path))
+		changed, err := writeFile(path, []byte("b"), 0600, defaults)
+		require.NoError(t, err)
+		require.True(t, changed)
+		info, err := os.Lstat(path)
+		require.NoError(t, err)
+		require.True(t, info.Mode()&os.ModeSymlink != 0, "the symlink is kept")
+		requireFile(t, target, "b", 0644)
+
+		entries, err := ioutil.ReadDir(filepath.Dir(path))
+		require.NoError(t, err)
+		require.Len(t, entries, 1, "temporary files are created next to the target")
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		path := existing(t, "a", 0644)
+		gid := os.Getgid()
+		_, err := writeFile(path, []byte("b"), 0600, fileOptions{uid: -1, gid: gid})
+		require.NoError(t, err)
+		info := requireFile(t, path, "b", 0644)
+		_, ownerGID, ok := fileOwner(info)
+		require.True(t, ok)
+		require.Equal(t, gid, ownerGID)
+	})
+}
+
+func TestParseFileOptions(t *testing.T) {
+	opts, err := parseFileOptions("", "", "", false)
+	require.NoError(t, err)
+	require.Equal(t, fileOptions{uid: -1, gid: -1}, opts)
+
+	opts, err = parseFileOptions("0640", "1000", "1001", true)
+	require.NoError(t, err)
+	require.Equal(t, fileOptions{mode: 0640, keepMode: true, uid: 1000, gid: 1001}, opts)
+
+	opts, err = parseFileOptions("644", "", "", false)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0644), opts.mode)
+
+	for _, mode := range []string{"rw-r--r--", "0999", "01777"} {
+		_, err = parseFileOptions(mode, "", "", false)
+		require.Error(t, err, mode)
+	}
+
+	_, err = parseFileOptions("", "tpl-no-such-user", "", false)
+	require.Error(t, err)
+	_, err = parseFileOptions("", "", "tpl-no-such-group", false)
+	require.Error(t, err)
+
+	if u, err := user.Current(); err == nil {
+		opts, err = parseFileOptions("", u.Username, "", false)
+		require.NoError(t, err)
+		uid, _ := strconv.Atoi(u.Uid)
+		require.Equal(t, uid, opts.uid)
+	}
+}
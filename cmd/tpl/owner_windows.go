@@ -0,0 +1,10 @@
+package main
+
+import (
+	"os"
+)
+
+// fileOwner is not supported on Windows.
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
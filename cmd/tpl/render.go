@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/zerok/tpl/internal/world"
+)
+
+const templateSuffix = ".tpl"
+
+// target describes what should be rendered: either a single template (`-`
+// for stdin) written to outputFile (stdout if empty) or a whole directory
+// tree.
+type target struct {
+	input      string
+	outputFile string
+	inputDir   string
+	outputDir  string
+}
+
+// render sets up a new world and renders the target through it. The world
+// is also returned if rendering failed so that the files it accessed can be
+// inspected. The audit report is written in both cases and the error of
+// doing so is returned separately from the render errors.
+func render(ctx context.Context, cfg *worldConfig, t *target, out *outputWriter) (*world.World, []error, error) {
+	w, err := cfg.newWorld(ctx)
+	if err != nil {
+		return nil, []error{err}, nil
+	}
+	out.world = w
+	errs := renderTarget(w, t, out)
+	if n := w.UnredactedSecrets(); n > 0 && (out.redact || out.diff) {
+		zerolog.Ctx(ctx).Warn().Msgf("%d secret value(s) were not redacted as they are shorter than %d characters, numbers or booleans", n, world.MinRedactedLength)
+	}
+	var auditErr error
+	if out.auditFile != "" {
+		auditErr = out.writeAudit(w)
+	}
+	return w, errs, auditErr
+}
+
+// renderTarget renders the template or directory of t through w.
+func renderTarget(w *world.World, t *target, out *outputWriter) []error {
+	if t.inputDir != "" {
+		return renderDir(w, out, t.inputDir, t.outputDir)
+	}
+	var rd io.Reader = os.Stdin
+	var path string
+	if t.input != "-" {
+		fp, err := os.Open(t.input)
+		if err != nil {
+			return []error{errors.Wrapf(err, "failed to open template %s", t.input)}
+		}
+		defer fp.Close()
+		rd = fp
+		path = t.input
+	}
+	var output bytes.Buffer
+	if err := w.RenderTemplate(&output, rd, path); err != nil {
+		return []error{err}
+	}
+	if t.outputFile == "" {
+		if _, err := os.Stdout.Write(out.content(output.Bytes())); err != nil {
+			return []error{err}
+		}
+		return nil
+	}
+	if err := out.write(t.outputFile, output.Bytes(), 0600); err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+// renderDir renders every *.tpl file below inputDir into the same location
+// below outputDir with the suffix removed. All other files are copied
+// verbatim. File modes are preserved. Symlinks are followed, so linked files
+// and directories end up in the output as regular ones. Instead of aborting
+// on the first failing file, all errors are collected and returned together.
+func renderDir(w *world.World, out *outputWriter, inputDir, outputDir string) []error {
+	absOutputDir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return []error{err}
+	}
+	r := &dirRenderer{world: w, out: out, absOutputDir: absOutputDir, walking: make(map[string]bool)}
+	r.walk(inputDir, outputDir)
+	return r.errs
+}
+
+// dirRenderer keeps the state of renderDir while walking the input tree.
+type dirRenderer struct {
+	world        *world.World
+	out          *outputWriter
+	absOutputDir string
+	errs         []error
+
+	// walking contains the resolved paths of the directories currently being
+	// walked in order to detect symlink loops.
+	walking map[string]bool
+}
+
+func (r *dirRenderer) walk(inputDir, outputDir string) {
+	resolved, err := filepath.EvalSymlinks(inputDir)
+	if err != nil {
+		r.errs = append(r.errs, err)
+		return
+	}
+	if r.walking[resolved] {
+		r.errs = append(r.errs, errors.Errorf("%s: symlink loop", inputDir))
+		return
+	}
+	r.walking[resolved] = true
+	defer delete(r.walking, resolved)
+
+	err = filepath.Walk(resolved, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			r.errs = append(r.errs, err)
+			return nil
+		}
+		rel, err := filepath.Rel(resolved, path)
+		if err != nil {
+			r.errs = append(r.errs, err)
+			return nil
+		}
+		source := filepath.Join(inputDir, rel)
+		target := filepath.Join(outputDir, rel)
+		if info.Mode()&os.ModeSymlink != 0 {
+			// Walk doesn't follow symlinks: use the mode of the linked
+			// file and walk linked directories separately.
+			if info, err = os.Stat(path); err != nil {
+				r.errs = append(r.errs, errors.Wrapf(err, "%s", source))
+				return nil
+			}
+			if info.IsDir() {
+				if !r.isOutputDir(path) {
+					r.walk(source, target)
+				}
+				return nil
+			}
+		}
+		if info.IsDir() {
+			// Don't render the output again if it is located inside the
+			// input directory.
+			if r.isOutputDir(path) {
+				return filepath.SkipDir
+			}
+		}
+		mode := info.Mode().Perm()
+		switch {
+		case info.IsDir():
+			if !r.out.dryRun() {
+				err = os.MkdirAll(target, mode|0700)
+			}
+		case strings.HasSuffix(path, templateSuffix):
+			err = renderFile(r.world, r.out, source, strings.TrimSuffix(target, templateSuffix), mode)
+		default:
+			err = copyFile(r.out, source, target, mode)
+		}
+		if err != nil {
+			r.errs = append(r.errs, errors.Wrapf(err, "%s", source))
+		}
+		return nil
+	})
+	if err != nil {
+		r.errs = append(r.errs, err)
+	}
+}
+
+// isOutputDir checks if path is the output directory.
+func (r *dirRenderer) isOutputDir(path string) bool {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	if resolved, err := filepath.EvalSymlinks(r.absOutputDir); err == nil {
+		return abs == resolved
+	}
+	return abs == r.absOutputDir
+}
+
+func renderFile(w *world.World, out *outputWriter, path, target string, mode os.FileMode) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	var output bytes.Buffer
+	if err := w.RenderTemplate(&output, fp, path); err != nil {
+		return err
+	}
+	return out.writeWithMode(target, output.Bytes(), mode)
+}
+
+func copyFile(out *outputWriter, path, target string, mode os.FileMode) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return out.writeWithMode(target, content, mode)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zerok/tpl/internal/world"
+)
+
+func TestRenderDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes and symlinks are not supported on Windows")
+	}
+	defaults := fileOptions{uid: -1, gid: -1}
+	writeInput := func(t *testing.T, path, content string, mode os.FileMode) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), mode))
+		require.NoError(t, os.Chmod(path, mode))
+	}
+	requireFile := func(t *testing.T, path, content string, mode os.FileMode) {
+		info, err := os.Lstat(path)
+		require.NoError(t, err)
+		require.True(t, info.Mode().IsRegular(), "%s is not a regular file", path)
+		require.Equal(t, mode, info.Mode().Perm())
+		raw, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, content, string(raw))
+	}
+	render := func(t *testing.T, input, output string, opts fileOptions) []error {
+		w := world.New(context.Background(), nil)
+		return renderDir(w, &outputWriter{file: opts}, input, output)
+	}
+
+	t.Run("files", func(t *testing.T) {
+		input := t.TempDir()
+		output := t.TempDir()
+		writeInput(t, filepath.Join(input, "app.conf.tpl"), `name={{ "app" }}`, 0640)
+		writeInput(t, filepath.Join(input, "sub", "run.sh"), "#!/bin/sh\n", 0755)
+		require.Empty(t, render(t, input, output, defaults))
+		requireFile(t, filepath.Join(output, "app.conf"), "name=app", 0640)
+		requireFile(t, filepath.Join(output, "sub", "run.sh"), "#!/bin/sh\n", 0755)
+	})
+
+	t.Run("existing-output", func(t *testing.T) {
+		input := t.TempDir()
+		output := t.TempDir()
+		writeInput(t, filepath.Join(input, "app.conf.tpl"), `name={{ "app" }}`, 0600)
+		writeInput(t, filepath.Join(output, "app.conf"), "name=old", 0644)
+		require.Empty(t, render(t, input, output, defaults))
+		requireFile(t, filepath.Join(output, "app.conf"), "name=app", 0600)
+
+		// Unchanged content still gets the mode of the template.
+		require.NoError(t, os.Chmod(filepath.Join(output, "app.conf"), 0644))
+		require.Empty(t, render(t, input, output, defaults))
+		requireFile(t, filepath.Join(output, "app.conf"), "name=app", 0600)
+
+		require.Empty(t, render(t, input, output, fileOptions{mode: 0640, uid: -1, gid: -1}))
+		requireFile(t, filepath.Join(output, "app.conf"), "name=app", 0640)
+
+		require.Empty(t, render(t, input, output, fileOptions{keepMode: true, uid: -1, gid: -1}))
+		requireFile(t, filepath.Join(output, "app.conf"), "name=app", 0640)
+	})
+
+	t.Run("symlinks", func(t *testing.T) {
+		shared := t.TempDir()
+		writeInput(t, filepath.Join(shared, "file.txt"), "shared", 0600)
+		writeInput(t, filepath.Join(shared, "dir", "nested.conf.tpl"), `{{ "nested" }}`, 0640)
+
+		input := t.TempDir()
+		output := t.TempDir()
+		require.NoError(t, os.Symlink(filepath.Join(shared, "file.txt"), filepath.Join(input, "file.txt")))
+		require.NoError(t, os.Symlink(filepath.Join(shared, "dir"), filepath.Join(input, "dir")))
+		require.Empty(t, render(t, input, output, defaults))
+		requireFile(t, filepath.Join(output, "file.txt"), "shared", 0600)
+		requireFile(t, filepath.Join(output, "dir", "nested.conf"), "nested", 0640)
+	})
+
+	t.Run("symlink-loop", func(t *testing.T) {
+		input := t.TempDir()
+		output := t.TempDir()
+		writeInput(t, filepath.Join(input, "a.txt"), "a", 0644)
+		require.NoError(t, os.Symlink(input, filepath.Join(input, "loop")))
+		errs := render(t, input, output, defaults)
+		require.Len(t, errs, 1)
+		require.Contains(t, errs[0].Error(), "symlink loop")
+		requireFile(t, filepath.Join(output, "a.txt"), "a", 0644)
+	})
+
+	t.Run("output-inside-input", func(t *testing.T) {
+		input := t.TempDir()
+		output := filepath.Join(input, "out")
+		writeInput(t, filepath.Join(input, "a.txt"), "a", 0644)
+		require.NoError(t, os.Symlink(output, filepath.Join(input, "link")))
+		require.NoError(t, os.MkdirAll(output, 0755))
+		require.Empty(t, render(t, input, output, defaults))
+		requireFile(t, filepath.Join(output, "a.txt"), "a", 0644)
+		_, err := os.Stat(filepath.Join(output, "out"))
+		require.True(t, os.IsNotExist(err))
+		_, err = os.Stat(filepath.Join(output, "link"))
+		require.True(t, os.IsNotExist(err))
+	})
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"os"
+)
+
+// signals lists the signals that can be sent to other processes by name.
+var signals = map[string]os.Signal{
+	"KILL": os.Kill,
+}
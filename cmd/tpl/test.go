@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/pflag"
+	"github.com/zerok/tpl/internal/world"
+)
+
+// runTest implements `tpl test`: every case of the *.tpl.test.yaml files
+// found is rendered with mocked data, environment, secrets and shell output
+// and compared with its golden file. The exit code is 1 if any case failed.
+func runTest(args []string) {
+	var cfg worldConfig
+	var update bool
+	var verbose bool
+	flags := pflag.NewFlagSet("test", pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf("Usage: tpl test [options] [path...]\n\nRuns the test cases found in *%s files (defaults to the current directory).\n\n", world.TemplateTestSuffix)
+		flags.PrintDefaults()
+	}
+	flags.BoolVar(&update, "update", false, "Write the rendered output to the golden files instead of comparing it")
+	flags.BoolVar(&verbose, "verbose", false, "Verbose log output")
+	cfg.registerTemplateFlags(flags)
+	flags.Parse(args)
+
+	logger := newToolLogger(verbose)
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := world.FindTemplateTests(paths)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to find tests")
+	}
+	if len(files) == 0 {
+		logger.Fatal().Msgf("No *%s files found", world.TemplateTestSuffix)
+	}
+	ctx := logger.WithContext(context.Background())
+	opts := &world.Options{
+		LeftDelim:   cfg.leftDelim,
+		RightDelim:  cfg.rightDelim,
+		Partials:    cfg.partials,
+		IncludeDirs: cfg.includeDirs,
+		Strict:      cfg.strict,
+		LogOutput:   newLogOutput(),
+	}
+	var passed, failed int
+	for _, file := range files {
+		test, err := world.LoadTemplateTest(file)
+		if err != nil {
+			fmt.Printf("FAIL %s\n%s", file, indent(err.Error()))
+			failed++
+			continue
+		}
+		for _, c := range test.Cases {
+			name := fmt.Sprintf("%s: %s", file, c.Name)
+			result, err := runTestCase(ctx, test, c, opts, update)
+			if err != nil {
+				fmt.Printf("FAIL %s\n%s", name, indent(err.Error()))
+				failed++
+				continue
+			}
+			fmt.Printf("%-4s %s\n", result, name)
+			passed++
+		}
+	}
+	fmt.Printf("\n%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// runTestCase renders the case and compares the output with the golden file
+// (or replaces the golden file if update is set). The error contains a diff
+// if the output doesn't match.
+func runTestCase(ctx context.Context, test *world.TemplateTest, c world.TemplateTestCase, opts *world.Options, update bool) (string, error) {
+	output, err := test.Render(ctx, c, opts)
+	if err != nil {
+		return "", err
+	}
+	golden := test.GoldenPath(c)
+	expected, err := ioutil.ReadFile(golden)
+	if os.IsNotExist(err) && !update {
+		return "", errors.Errorf("golden file %s does not exist (use --update to create it)", golden)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", errors.Wrap(err, "failed to read golden file")
+	}
+	if string(expected) == output {
+		return "ok", nil
+	}
+	if update {
+		if err := ioutil.WriteFile(golden, []byte(output), 0644); err != nil {
+			return "", errors.Wrap(err, "failed to update golden file")
+		}
+		return "updated", nil
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        diffLines(string(expected)),
+		B:        diffLines(output),
+		FromFile: golden,
+		ToFile:   golden + " (rendered)",
+		Context:  3,
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to diff %s", golden)
+	}
+	return "", errors.New("output differs from golden file:\n" + diff)
+}
+
+// indent prefixes every line of s with two spaces and makes sure it ends
+// with a newline.
+func indent(s string) string {
+	var b strings.Builder
+	for _, line := range diffLines(s) {
+		io.WriteString(&b, "  "+line)
+	}
+	return b.String()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// watchDebounce is the time to wait after a change before rendering again so
+// that multiple changes in a row (e.g. when an editor saves a file) only
+// trigger a single render.
+const watchDebounce = 250 * time.Millisecond
+
+// watchTarget renders the target and renders it again whenever one of the
+// files it depends on changes. Render errors are only logged and the last
+// successfully rendered output stays in place. It only returns once the
+// context is done or the file watcher fails.
+func watchTarget(ctx context.Context, cfg *worldConfig, t *target, out *outputWriter) error {
+	logger := zerolog.Ctx(ctx)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "failed to set up file watcher")
+	}
+	defer watcher.Close()
+
+	var inputDir, outputDir string
+	if t.inputDir != "" {
+		if inputDir, err = filepath.Abs(t.inputDir); err != nil {
+			return err
+		}
+		if outputDir, err = filepath.Abs(t.outputDir); err != nil {
+			return err
+		}
+	}
+	watchedDirs := make(map[string]struct{})
+	watch := func(dir string) {
+		if _, ok := watchedDirs[dir]; ok {
+			return
+		}
+		if err := watcher.Add(dir); err != nil {
+			logger.Warn().Err(err).Msgf("Failed to watch %s", dir)
+			return
+		}
+		watchedDirs[dir] = struct{}{}
+	}
+
+	// Files are watched through their parent directory so that files which
+	// are replaced (as many editors do) or do not exist yet are covered too.
+	files := make(map[string]struct{})
+	renderAndWatch := func() {
+		w, errs, auditErr := render(ctx, cfg, t, out)
+		for _, err := range errs {
+			logger.Error().Err(err).Msg("Failed to render")
+		}
+		if auditErr != nil {
+			logger.Error().Err(auditErr).Msg("Failed to write audit report")
+		}
+		if len(errs) == 0 {
+			logger.Info().Msg("Rendered successfully")
+		}
+		paths := cfg.files()
+		if t.input != "" {
+			if abs, err := filepath.Abs(t.input); err == nil {
+				paths = append(paths, abs)
+			}
+		}
+		if w != nil {
+			paths = append(paths, w.Files()...)
+		}
+		files = make(map[string]struct{}, len(paths))
+		for _, path := range paths {
+			files[path] = struct{}{}
+			watch(filepath.Dir(path))
+		}
+		if inputDir != "" {
+			filepath.Walk(inputDir, func(path string, info os.FileInfo, err error) error {
+				if err != nil || !info.IsDir() {
+					return nil
+				}
+				if path == outputDir {
+					return filepath.SkipDir
+				}
+				watch(path)
+				return nil
+			})
+		}
+	}
+	relevant := func(path string) bool {
+		if _, ok := files[path]; ok {
+			return true
+		}
+		if inputDir == "" || isInside(path, outputDir) {
+			return false
+		}
+		return isInside(path, inputDir)
+	}
+
+	renderAndWatch()
+	logger.Info().Msg("Watching for changes")
+	var rerender <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !relevant(event.Name) {
+				continue
+			}
+			logger.Debug().Msgf("%s changed (%s)", event.Name, event.Op)
+			rerender = time.After(watchDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return errors.Wrap(err, "file watcher failed")
+		case <-rerender:
+			rerender = nil
+			logger.Info().Msg("Change detected, rendering again")
+			renderAndWatch()
+		}
+	}
+}
+
+// isInside checks if path is dir or located somewhere below it.
+func isInside(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
@@ -3,14 +3,19 @@ module github.com/zerok/tpl
 go 1.13
 
 require (
+	filippo.io/age v1.0.0
+	github.com/BurntSushi/toml v1.2.1
 	github.com/Masterminds/sprig/v3 v3.2.2
+	github.com/fsnotify/fsnotify v1.5.4
 	github.com/google/uuid v1.2.0 // indirect
 	github.com/hashicorp/go-retryablehttp v0.7.7
+	github.com/hashicorp/hcl v1.0.0
 	github.com/hashicorp/vault/api v1.1.0
 	github.com/huandu/xstrings v1.3.2 // indirect
 	github.com/imdario/mergo v0.3.12 // indirect
 	github.com/jmespath/go-jmespath v0.4.0
 	github.com/pkg/errors v0.9.1
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/rs/zerolog v1.24.0
 	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.7.2
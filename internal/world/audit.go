@@ -0,0 +1,186 @@
+package world
+
+import (
+	"sort"
+	"text/template"
+	"text/template/parse"
+)
+
+// Kinds of file accesses recorded in the audit report.
+const (
+	FileAccessTemplate = "template"
+	FileAccessRead     = "read"
+	FileAccessExists   = "exists"
+)
+
+// AuditReport lists everything the renders of a world depended on. It
+// never contains secret values.
+type AuditReport struct {
+	Secrets []SecretAccess `json:"secrets"`
+	Files   []FileAccess   `json:"files"`
+
+	// Env lists the environment variables read while rendering.
+	Env []string `json:"env"`
+
+	// EnvReferences lists the environment variables referenced through
+	// .Env. They are taken from the templates and therefore include
+	// references that were never executed (e.g. inside an if block).
+	EnvReferences []string `json:"envReferences"`
+
+	Commands []CommandRun `json:"commands"`
+}
+
+// SecretAccess describes a secret requested by a template.
+type SecretAccess struct {
+	Backend string `json:"backend"`
+
+	// Path as written in the template.
+	Path string `json:"path"`
+
+	// ResolvedPath is the path after applying prefix and key mapping.
+	ResolvedPath string `json:"resolvedPath"`
+
+	Field   string `json:"field,omitempty"`
+	Version string `json:"version,omitempty"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// FileAccess describes a file used as template or accessed through FS.
+type FileAccess struct {
+	Path   string `json:"path"`
+	Access string `json:"access"`
+}
+
+// CommandRun describes a command executed through System.ShellOutput.
+type CommandRun struct {
+	Command string `json:"command"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+type secretAccessKey struct {
+	backend string
+	ref     SecretRef
+}
+
+// audit collects the accesses of a world.
+type audit struct {
+	secrets  map[secretAccessKey]SecretAccess
+	files    map[FileAccess]struct{}
+	env      map[string]struct{}
+	envRefs  map[string]struct{}
+	commands map[string]CommandRun
+}
+
+func newAudit() *audit {
+	return &audit{
+		secrets:  make(map[secretAccessKey]SecretAccess),
+		files:    make(map[FileAccess]struct{}),
+		env:      make(map[string]struct{}),
+		envRefs:  make(map[string]struct{}),
+		commands: make(map[string]CommandRun),
+	}
+}
+
+func (w *World) recordSecretAccess(backend string, ref SecretRef, resolved string, err error) {
+	access := SecretAccess{
+		Backend:      backend,
+		Path:         ref.Path,
+		ResolvedPath: resolved,
+		Field:        ref.Field,
+		Version:      ref.Version,
+		Success:      err == nil,
+	}
+	if err != nil {
+		access.Error = w.Redact(err.Error())
+	}
+	w.auditMu.Lock()
+	defer w.auditMu.Unlock()
+	w.audit.secrets[secretAccessKey{backend, ref}] = access
+}
+
+func (w *World) recordEnv(name string) {
+	w.auditMu.Lock()
+	defer w.auditMu.Unlock()
+	w.audit.env[name] = struct{}{}
+}
+
+func (w *World) recordCommand(cmd string, err error) {
+	cmd = w.Redact(cmd)
+	run := CommandRun{Command: cmd, Success: err == nil}
+	if err != nil {
+		run.Error = w.Redact(err.Error())
+	}
+	w.auditMu.Lock()
+	defer w.auditMu.Unlock()
+	w.audit.commands[cmd] = run
+}
+
+// recordEnvReferences records the environment variables referenced through
+// .Env. As those are plain map accesses, they cannot be recorded while
+// rendering and are taken from the template instead.
+func (w *World) recordEnvReferences(tmpl *template.Template) {
+	w.auditMu.Lock()
+	defer w.auditMu.Unlock()
+	walkFields(tmpl, func(ident []string, node parse.Node, tree *parse.Tree) {
+		if len(ident) >= 2 && ident[0] == "Env" {
+			w.audit.envRefs[ident[1]] = struct{}{}
+		}
+	})
+}
+
+// AuditReport returns everything accessed by the renders of this world so
+// far in a stable order.
+func (w *World) AuditReport() AuditReport {
+	w.auditMu.Lock()
+	defer w.auditMu.Unlock()
+	report := AuditReport{
+		Secrets:       make([]SecretAccess, 0, len(w.audit.secrets)),
+		Files:         make([]FileAccess, 0, len(w.audit.files)),
+		Env:           make([]string, 0, len(w.audit.env)),
+		EnvReferences: make([]string, 0, len(w.audit.envRefs)),
+		Commands:      make([]CommandRun, 0, len(w.audit.commands)),
+	}
+	for _, access := range w.audit.secrets {
+		report.Secrets = append(report.Secrets, access)
+	}
+	sort.Slice(report.Secrets, func(i, j int) bool {
+		a, b := report.Secrets[i], report.Secrets[j]
+		if a.Backend != b.Backend {
+			return a.Backend < b.Backend
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Field != b.Field {
+			return a.Field < b.Field
+		}
+		return a.Version < b.Version
+	})
+	for access := range w.audit.files {
+		report.Files = append(report.Files, access)
+	}
+	sort.Slice(report.Files, func(i, j int) bool {
+		a, b := report.Files[i], report.Files[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Access < b.Access
+	})
+	for name := range w.audit.env {
+		report.Env = append(report.Env, name)
+	}
+	sort.Strings(report.Env)
+	for name := range w.audit.envRefs {
+		report.EnvReferences = append(report.EnvReferences, name)
+	}
+	sort.Strings(report.EnvReferences)
+	for _, run := range w.audit.commands {
+		report.Commands = append(report.Commands, run)
+	}
+	sort.Slice(report.Commands, func(i, j int) bool {
+		return report.Commands[i].Command < report.Commands[j].Command
+	})
+	return report
+}
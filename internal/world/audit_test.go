@@ -0,0 +1,53 @@
+package world_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zerok/tpl/internal/world"
+)
+
+func TestAuditReport(t *testing.T) {
+	w := world.New(context.Background(), &world.Options{
+		Insecure: true,
+		EnvOnly:  true,
+		Env:      map[string]string{"APP_ENV": "prod", "REGION": "eu"},
+	})
+	p := &fakeProvider{secrets: map[string]string{
+		"prod/app#password": "s3cret",
+	}}
+	p.Prefix = "prod/"
+	w.RegisterSecretProvider("fake", func() world.SecretProvider { return p })
+
+	tmpl := `{{ secret "fake://app#password" }}
+{{ .Env.APP_ENV }} {{ env "REGION" }} {{ index .Env "HOME" }} {{ if false }}{{ .Env.UNUSED }}{{ end }}
+{{ .FS.Exists "testdata/missing" }} {{ .FS.ReadFile "../../testdata/env/base.env" }}
+{{ .System.ShellOutput "echo s3cret" }}
+{{ secret "fake://app?version=2#password" }}`
+	var out bytes.Buffer
+	err := w.Render(&out, bytes.NewBufferString(tmpl))
+	require.Error(t, err)
+
+	report := w.AuditReport()
+	require.Equal(t, []world.SecretAccess{
+		{Backend: "fake", Path: "app", ResolvedPath: "prod/app", Field: "password", Success: true},
+		{Backend: "fake", Path: "app", ResolvedPath: "prod/app", Field: "password", Version: "2", Error: "fake: failed to retrieve secret app?version=2#password: prod/app?version=2#password not found"},
+	}, report.Secrets)
+	require.Equal(t, []string{"REGION"}, report.Env)
+	require.Equal(t, []string{"APP_ENV", "HOME", "UNUSED"}, report.EnvReferences)
+	missing, _ := filepath.Abs("testdata/missing")
+	env, _ := filepath.Abs("../../testdata/env/base.env")
+	require.Equal(t, []world.FileAccess{
+		{Path: missing, Access: world.FileAccessExists},
+		{Path: env, Access: world.FileAccessRead},
+	}, report.Files)
+	require.Equal(t, []world.CommandRun{{Command: "echo ***", Success: true}}, report.Commands)
+
+	raw, err := json.Marshal(report)
+	require.NoError(t, err)
+	require.NotContains(t, string(raw), "s3cret")
+}
@@ -0,0 +1,344 @@
+package world
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+const (
+	AWSAccessKeyID                string = "AWS_ACCESS_KEY_ID"
+	AWSSecretAccessKey            string = "AWS_SECRET_ACCESS_KEY"
+	AWSSessionToken               string = "AWS_SESSION_TOKEN"
+	AWSRegion                     string = "AWS_REGION"
+	AWSDefaultRegion              string = "AWS_DEFAULT_REGION"
+	AWSEndpointURL                string = "AWS_ENDPOINT_URL"
+	AWSEndpointURLSecretsManager  string = "AWS_ENDPOINT_URL_SECRETS_MANAGER"
+	AWSEndpointURLSSM             string = "AWS_ENDPOINT_URL_SSM"
+	awsSecretsManagerService      string = "secretsmanager"
+	awsSSMService                 string = "ssm"
+	awsSecretsManagerTarget       string = "secretsmanager.GetSecretValue"
+	awsSSMTarget                  string = "AmazonSSM.GetParameter"
+	awsSigningAlgorithm           string = "AWS4-HMAC-SHA256"
+	awsTimeFormat                 string = "20060102T150405Z"
+	awsDateFormat                 string = "20060102"
+	awsJSONContentType            string = "application/x-amz-json-1.1"
+	awsSecretsManagerStagePrefix  string = "AWS"
+	awsSecretsManagerDefaultStage string = "AWSCURRENT"
+)
+
+// AWS provides access to secrets stored in AWS Secrets Manager and
+// parameters stored in the SSM Parameter Store.
+type AWS struct {
+	PathMapping
+	ctx                    context.Context
+	world                  *World
+	accessKeyID            string
+	secretAccessKey        string
+	sessionToken           string
+	region                 string
+	secretsManagerEndpoint string
+	ssmEndpoint            string
+}
+
+type awsSecretValue struct {
+	SecretString string `json:"SecretString"`
+	SecretBinary string `json:"SecretBinary"`
+}
+
+type awsParameterValue struct {
+	Parameter struct {
+		Value string `json:"Value"`
+	} `json:"Parameter"`
+}
+
+type awsErrorResponse struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+// awsParameters exposes the SSM Parameter Store part of AWS as separate
+// SecretProvider while sharing the path mapping.
+type awsParameters struct {
+	*AWS
+}
+
+func (p awsParameters) FetchSecret(ref SecretRef) (string, error) {
+	return p.fetchParameter(ref)
+}
+
+// ExtractField treats the parameter as JSON document and returns the value
+// the field (a JMESPath expression) points to.
+func (p awsParameters) ExtractField(document string, ref SecretRef) (string, error) {
+	return jsonField(document, ref.Field)
+}
+
+func (p awsParameters) cacheScope() string {
+	return p.region + "|" + p.ssmEndpoint + "|" + cacheIdentity(p.accessKeyID)
+}
+
+func (w *World) AWS() *AWS {
+	if w.aws != nil {
+		return w.aws
+	}
+	logger := zerolog.Ctx(w.ctx).With().Str("component", "AWS").Logger()
+	ctx := logger.WithContext(w.ctx)
+	accessKeyID := w.getenv(AWSAccessKeyID)
+	secretAccessKey := w.getenv(AWSSecretAccessKey)
+	region := w.getenv(AWSRegion)
+	if region == "" {
+		region = w.getenv(AWSDefaultRegion)
+	}
+	endpoint := w.getenv(AWSEndpointURL)
+	secretsManagerEndpoint := w.getenv(AWSEndpointURLSecretsManager)
+	if secretsManagerEndpoint == "" {
+		secretsManagerEndpoint = endpoint
+	}
+	ssmEndpoint := w.getenv(AWSEndpointURLSSM)
+	if ssmEndpoint == "" {
+		ssmEndpoint = endpoint
+	}
+
+	if accessKeyID == "" || secretAccessKey == "" {
+		logger.Warn().Msgf("%s and %s need to be set", AWSAccessKeyID, AWSSecretAccessKey)
+	}
+	if region == "" {
+		logger.Warn().Msgf("%s or %s needs to be set", AWSRegion, AWSDefaultRegion)
+	}
+
+	w.aws = &AWS{
+		ctx:                    ctx,
+		world:                  w,
+		PathMapping:            PathMapping{KeyMapping: make(map[string]string)},
+		accessKeyID:            accessKeyID,
+		secretAccessKey:        secretAccessKey,
+		sessionToken:           w.getenv(AWSSessionToken),
+		region:                 region,
+		secretsManagerEndpoint: secretsManagerEndpoint,
+		ssmEndpoint:            ssmEndpoint,
+	}
+	return w.aws
+}
+
+// Secret returns the current value of the given Secrets Manager secret. If a
+// key is passed, the secret is treated as JSON document and the key (a
+// JMESPath expression) is extracted from it.
+func (a *AWS) Secret(name string, key ...string) (string, error) {
+	if len(key) > 1 {
+		return "", errors.New("only a single key can be requested")
+	}
+	ref := SecretRef{Path: name}
+	if len(key) == 1 {
+		ref.Field = key[0]
+	}
+	return a.world.lookupSecret("aws", ref)
+}
+
+// Parameter returns the decrypted value of the given SSM parameter.
+func (a *AWS) Parameter(path string) (string, error) {
+	return a.world.lookupSecret("ssm", SecretRef{Path: path})
+}
+
+func (a *AWS) cacheScope() string {
+	return a.region + "|" + a.secretsManagerEndpoint + "|" + cacheIdentity(a.accessKeyID)
+}
+
+// FetchSecret implements SecretProvider for Secrets Manager. Versions
+// starting with AWS (like AWSPREVIOUS) are treated as version stages, all
+// others as version IDs.
+func (a *AWS) FetchSecret(ref SecretRef) (string, error) {
+	req := map[string]string{"SecretId": ref.Path}
+	switch {
+	case ref.Version == "":
+		req["VersionStage"] = awsSecretsManagerDefaultStage
+	case strings.HasPrefix(ref.Version, awsSecretsManagerStagePrefix):
+		req["VersionStage"] = ref.Version
+	default:
+		req["VersionId"] = ref.Version
+	}
+	secret, err := a.getSecretValue(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not get secret %s", ref.Path)
+	}
+	if ref.Field != "" {
+		return jsonField(secret, ref.Field)
+	}
+	return secret, nil
+}
+
+// ExtractField treats the secret as JSON document and returns the value the
+// field (a JMESPath expression) points to.
+func (a *AWS) ExtractField(document string, ref SecretRef) (string, error) {
+	return jsonField(document, ref.Field)
+}
+
+// getSecretValue returns the secret requested through req.
+func (a *AWS) getSecretValue(req map[string]string) (string, error) {
+	body, err := a.doRequest(awsSecretsManagerService, a.secretsManagerEndpoint, awsSecretsManagerTarget, req)
+	if err != nil {
+		return "", err
+	}
+	var value awsSecretValue
+	if err := json.Unmarshal(body, &value); err != nil {
+		return "", errors.Wrap(err, "could not unmarshal secret response")
+	}
+	secret := value.SecretString
+	if secret == "" && value.SecretBinary != "" {
+		raw, err := base64.StdEncoding.DecodeString(value.SecretBinary)
+		if err != nil {
+			return "", errors.Wrap(err, "could not decode binary secret")
+		}
+		secret = string(raw)
+	}
+	return secret, nil
+}
+
+func (a *AWS) fetchParameter(ref SecretRef) (string, error) {
+	name := ref.Path
+	if ref.Version != "" {
+		name = fmt.Sprintf("%s:%s", name, ref.Version)
+	}
+	req := map[string]interface{}{"Name": name, "WithDecryption": true}
+	body, err := a.doRequest(awsSSMService, a.ssmEndpoint, awsSSMTarget, req)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not get parameter %s", ref.Path)
+	}
+	var value awsParameterValue
+	if err := json.Unmarshal(body, &value); err != nil {
+		return "", errors.Wrap(err, "could not unmarshal parameter response")
+	}
+	if ref.Field != "" {
+		return jsonField(value.Parameter.Value, ref.Field)
+	}
+	return value.Parameter.Value, nil
+}
+
+func (a *AWS) doRequest(service, endpoint, target string, payload interface{}) ([]byte, error) {
+	logger := zerolog.Ctx(a.ctx)
+	if a.accessKeyID == "" || a.secretAccessKey == "" {
+		return nil, errors.New("no AWS credentials available")
+	}
+	if a.region == "" {
+		return nil, errors.New("no AWS region configured")
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", service, a.region)
+	}
+	u, err := url.ParseRequestURI(endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse endpoint URL")
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate request")
+	}
+	r.Header.Set("Content-Type", awsJSONContentType)
+	r.Header.Set("X-Amz-Target", target)
+	if a.sessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", a.sessionToken)
+	}
+	signAWSRequest(r, body, a.accessKeyID, a.secretAccessKey, a.region, service, time.Now())
+
+	retryClient := retryablehttp.NewClient()
+	retryClient.Logger = &LeveledZerolog{logger}
+	client := retryClient.StandardClient()
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "request failed")
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		var awsErr awsErrorResponse
+		if err := json.Unmarshal(respBody, &awsErr); err == nil && awsErr.Type != "" {
+			return nil, errors.Errorf("request returned error, code: %v (%s: %s)", resp.StatusCode, awsErr.Type, awsErr.Message)
+		}
+		return nil, errors.Errorf("request returned error, code: %v", resp.StatusCode)
+	}
+	return respBody, nil
+}
+
+// signAWSRequest adds an AWS Signature Version 4 to the given request. All
+// headers already present on the request are signed.
+func signAWSRequest(r *http.Request, body []byte, accessKeyID, secretAccessKey, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(awsTimeFormat)
+	date := now.Format(awsDateFormat)
+	r.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": r.URL.Host}
+	for name, values := range r.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		path,
+		r.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		awsSigningAlgorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		awsSigningAlgorithm, accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
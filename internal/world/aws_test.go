@@ -0,0 +1,95 @@
+package world
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestAWSSignature checks the signer against the get-vanilla example of the
+// AWS Signature Version 4 test suite.
+func TestAWSSignature(t *testing.T) {
+	r, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	require.NoError(t, err)
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signAWSRequest(r, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+	require.Equal(t, "20150830T123600Z", r.Header.Get("X-Amz-Date"))
+	require.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31", r.Header.Get("Authorization"))
+}
+
+func TestAWSSecrets(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body := make(map[string]interface{})
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case awsSecretsManagerTarget:
+			switch body["SecretId"] {
+			case "db":
+				fmt.Fprintf(w, `{"SecretString": "{\"user\": \"app\", \"password\": \"%s\"}"}`, body["VersionStage"])
+			case "prod/api-key":
+				fmt.Fprint(w, `{"SecretString": "key-123"}`)
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"__type": "ResourceNotFoundException", "message": "Secrets Manager can't find the specified secret."}`)
+			}
+		case awsSSMTarget:
+			if body["Name"] != "/app/db/host" || body["WithDecryption"] != true {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"__type": "ParameterNotFound"}`)
+				return
+			}
+			fmt.Fprint(w, `{"Parameter": {"Name": "/app/db/host", "Value": "db.internal"}}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv(AWSAccessKeyID, "AKID")
+	t.Setenv(AWSSecretAccessKey, "SECRET")
+	t.Setenv(AWSRegion, "eu-central-1")
+	t.Setenv(AWSEndpointURL, srv.URL)
+
+	tests := []struct {
+		input   string
+		output  string
+		errored bool
+	}{
+		{input: `{{ .AWS.Secret "db" "password" }}`, output: "AWSCURRENT"},
+		{input: `{{ .AWS.Secret "db" "user" }}`, output: "app"},
+		{input: `{{ secret "aws://db?version=AWSPREVIOUS#password" }}`, output: "AWSPREVIOUS"},
+		{input: `{{ .AWS.Parameter "/app/db/host" }}`, output: "db.internal"},
+		{input: `{{ secret "ssm:///app/db/host" }}`, output: "db.internal"},
+		{input: `{{ .AWS.Secret "missing" }}`, errored: true},
+		{input: `{{ .AWS.Secret "db" "missing" }}`, errored: true},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			w := New(context.Background(), &Options{})
+			if test.errored {
+				requireError(t, w, test.input)
+				return
+			}
+			require.Equal(t, test.output, requireRender(t, w, test.input))
+		})
+	}
+
+	t.Run("prefix", func(t *testing.T) {
+		w := New(context.Background(), &Options{})
+		w.AWS().Prefix = "prod/"
+		require.Equal(t, "key-123", requireRender(t, w, `{{ .AWS.Secret "api-key" }}`))
+	})
+}
@@ -7,8 +7,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
+	"sync"
 
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/pkg/errors"
@@ -48,15 +48,22 @@ type AzureSecretVersions struct {
 }
 
 type Azure struct {
+	PathMapping
 	ctx          context.Context
-	Prefix       string
-	KeyMapping   map[string]string
+	world        *World
 	keyVaultUrl  string
 	tenantId     string
 	clientId     string
 	clientSecret string
 	apiVersion   string
 	token        string
+
+	// identity is a hash of the token passed in or of the service principal
+	// the token is requested for.
+	identity string
+
+	// mu guards the token.
+	mu sync.Mutex
 }
 
 // LeveledZerolog implements the retryablehttp LeveledLogger interface
@@ -97,12 +104,12 @@ func (w *World) Azure() *Azure {
 	}
 	logger := zerolog.Ctx(w.ctx).With().Str("component", "Azure").Logger()
 	ctx := logger.WithContext(w.ctx)
-	tenantId := os.Getenv(AzureTenantId)
-	azureClientId := os.Getenv(AzureClientId)
-	azureClientSecret := os.Getenv(AzureClientSecret)
-	azureKeyVaultUrl := os.Getenv(AzureKeyVaultUrl)
-	azureApiVersion := os.Getenv(AzureApiVersion)
-	azureToken := os.Getenv(AzureToken)
+	tenantId := w.getenv(AzureTenantId)
+	azureClientId := w.getenv(AzureClientId)
+	azureClientSecret := w.getenv(AzureClientSecret)
+	azureKeyVaultUrl := w.getenv(AzureKeyVaultUrl)
+	azureApiVersion := w.getenv(AzureApiVersion)
+	azureToken := w.getenv(AzureToken)
 
 	if azureApiVersion == "" {
 		azureApiVersion = "7.0"
@@ -116,36 +123,62 @@ func (w *World) Azure() *Azure {
 		logger.Warn().Msgf("%s or %s, %s, %s needs to be set", AzureToken, AzureTenantId, AzureClientId, AzureClientSecret)
 	}
 
+	identity := cacheIdentity(tenantId, azureClientId)
+	if azureToken != "" {
+		identity = cacheIdentity(azureToken)
+	}
 	w.azure = &Azure{
 		ctx:          ctx,
-		KeyMapping:   make(map[string]string),
+		world:        w,
+		PathMapping:  PathMapping{KeyMapping: make(map[string]string)},
 		tenantId:     tenantId,
 		clientId:     azureClientId,
 		clientSecret: azureClientSecret,
 		keyVaultUrl:  azureKeyVaultUrl,
 		apiVersion:   azureApiVersion,
 		token:        azureToken,
+		identity:     identity,
 	}
 	return w.azure
 }
 
+// Secret returns the latest version of the secret stored at path.
 func (a *Azure) Secret(path string) (string, error) {
-	prefixPath := fmt.Sprintf("%s%s", a.Prefix, path)
-	mapped, ok := a.KeyMapping[prefixPath]
-	if !ok {
-		mapped = path
-	}
-	latestSecretVersion, err := a.getLatestSecretVersion(mapped)
-	if err != nil {
-		return "", errors.Wrapf(err, "could not get secrets version for %s", mapped)
+	return a.world.lookupSecret("azure", SecretRef{Path: path})
+}
+
+// FetchSecret implements SecretProvider. If a field is requested, the secret
+// is treated as JSON document and the field is extracted from it.
+func (a *Azure) FetchSecret(ref SecretRef) (string, error) {
+	mapped := ref.Path
+	secretVersion := ref.Version
+	if secretVersion == "" {
+		latestSecretVersion, err := a.getLatestSecretVersion(mapped)
+		if err != nil {
+			return "", errors.Wrapf(err, "could not get secrets version for %s", mapped)
+		}
+		secretVersion = latestSecretVersion
 	}
-	secret, err := a.getSecret(mapped, latestSecretVersion)
+	secret, err := a.getSecret(mapped, secretVersion)
 	if err != nil {
 		return "", errors.Wrapf(err, "could not get secrets for %s", mapped)
 	}
+	if ref.Field != "" {
+		return jsonField(secret, ref.Field)
+	}
 	return secret, nil
 }
 
+// ExtractField treats the secret as JSON document and returns the value the
+// field (a JMESPath expression) points to.
+func (a *Azure) ExtractField(document string, ref SecretRef) (string, error) {
+	return jsonField(document, ref.Field)
+}
+
+func (a *Azure) cacheScope() string {
+	return a.keyVaultUrl + "|" + a.identity
+}
+
 func (a *Azure) getSecret(path string, secretVersion string) (string, error) {
 	body, err := a.doVaultRequest(fmt.Sprintf("/secrets/%s/%s", path, secretVersion))
 	if err != nil {
@@ -182,11 +215,15 @@ func (a *Azure) getLatestSecretVersion(path string) (string, error) {
 
 func (a *Azure) doVaultRequest(urlPath string) ([]byte, error) {
 	logger := zerolog.Ctx(a.ctx)
+	a.mu.Lock()
 	if a.token == "" {
 		if err := a.getBearerToken(); err != nil {
+			a.mu.Unlock()
 			return nil, errors.Wrap(err, "failed to retrieve token")
 		}
 	}
+	token := a.token
+	a.mu.Unlock()
 	params := url.Values{}
 	params.Set("api-version", a.apiVersion)
 	u, err := url.ParseRequestURI(a.keyVaultUrl)
@@ -200,7 +237,7 @@ func (a *Azure) doVaultRequest(urlPath string) ([]byte, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to generate request")
 	}
-	r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", a.token))
+	r.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
 
 	retryClient := retryablehttp.NewClient()
 	retryClient.Logger = &LeveledZerolog{logger}
@@ -1,52 +1,357 @@
 package world
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 
+	"filippo.io/age"
+	"github.com/hashicorp/go-retryablehttp"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
 	yaml "gopkg.in/yaml.v2"
 )
 
+const dataExecPrefix = "exec:"
+
 // Data can be used to store arbitrary data (e.g. coming from data-files).
 type Data map[string]interface{}
 
+// DataOptions configures how data definitions are loaded.
+type DataOptions struct {
+	// AgeIdentities lists files containing age identities used to decrypt
+	// SOPS and age encrypted data files. SOPS_AGE_KEY_FILE and SOPS_AGE_KEY
+	// are used in addition to these.
+	AgeIdentities []string
+
+	// Insecure allows data to be loaded from the output of commands
+	// (exec:...).
+	Insecure bool
+
+	// Stdin is used for data definitions referencing `-`. Defaults to
+	// os.Stdin.
+	Stdin io.Reader
+
+	// Root lists data sources that are merged directly into the top-level
+	// of the data instead of being stored under a key.
+	Root []string
+
+	// ListMerge decides what happens with lists if a key is defined multiple
+	// times (ListMergeReplace or ListMergeAppend). Defaults to replacing.
+	ListMerge string
+
+	// Set, SetString and SetFile contain path=value assignments which are
+	// applied after all data files have been loaded (in that order). Values
+	// passed through Set are converted to booleans and numbers where
+	// possible, SetString keeps them as strings and SetFile uses the content
+	// of the referenced file.
+	Set       []string
+	SetString []string
+	SetFile   []string
+
+	// LookupEnv is used to look up SOPS_AGE_KEY and SOPS_AGE_KEY_FILE as
+	// well as the variables referenced in dotenv files. Defaults to
+	// os.LookupEnv.
+	LookupEnv func(string) (string, bool)
+}
+
+// Strategies for merging lists that are defined in multiple data files.
+const (
+	ListMergeReplace string = "replace"
+	ListMergeAppend  string = "append"
+)
+
 // LoadData fills a newly created Data object based on the given definitions.
 func LoadData(ctx context.Context, datadefs []string, cwd string) (Data, error) {
+	return LoadDataWithOptions(ctx, datadefs, cwd, nil)
+}
+
+// LoadDataWithOptions works like LoadData but allows further customization
+// of the loading process. Definitions sharing the same key are deep-merged
+// in the order they are given. Root definitions are merged into the top-level
+// of the result before any of the keyed definitions. Inline assignments
+// (Set, SetString and SetFile) take precedence over all files.
+func LoadDataWithOptions(ctx context.Context, datadefs []string, cwd string, opts *DataOptions) (Data, error) {
+	if opts == nil {
+		opts = &DataOptions{}
+	}
+	switch opts.ListMerge {
+	case "", ListMergeReplace, ListMergeAppend:
+	default:
+		return nil, errors.Errorf("unsupported list merge strategy `%s`", opts.ListMerge)
+	}
+	loader := &dataLoader{opts: opts, stdin: opts.Stdin}
+	if loader.stdin == nil {
+		loader.stdin = os.Stdin
+	}
 	result := Data{}
+	for _, root := range opts.Root {
+		value, err := loader.load(ctx, root, cwd, root)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("root data in `%s` is not a map", root)
+		}
+		for k, v := range m {
+			result[k] = mergeValues(result[k], v, opts.ListMerge)
+		}
+	}
 	for _, datadef := range datadefs {
 		elems := strings.SplitN(datadef, "=", 2)
 		if len(elems) != 2 {
 			return nil, errors.Errorf("invalid data definition `%s`", datadef)
 		}
 		key := elems[0]
-		file := elems[1]
-		var value interface{}
-		fp, err := os.Open(filepath.Join(cwd, file))
+		value, err := loader.load(ctx, elems[1], cwd, datadef)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = mergeValues(result[key], value, opts.ListMerge)
+	}
+	if err := applySets(result, opts, cwd); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DataFiles returns the local files that are read when loading the given
+// data definitions and options. Stdin, URLs and commands are skipped.
+func DataFiles(datadefs []string, cwd string, opts *DataOptions) []string {
+	if opts == nil {
+		opts = &DataOptions{}
+	}
+	var sources []string
+	sources = append(sources, opts.Root...)
+	for _, datadef := range datadefs {
+		elems := strings.SplitN(datadef, "=", 2)
+		if len(elems) == 2 {
+			sources = append(sources, elems[1])
+		}
+	}
+	for _, assignment := range opts.SetFile {
+		elems := strings.SplitN(assignment, "=", 2)
+		if len(elems) == 2 {
+			sources = append(sources, elems[1])
+		}
+	}
+	var result []string
+	for _, raw := range sources {
+		source, _ := splitFormat(raw)
+		if source == "-" || strings.HasPrefix(source, dataExecPrefix) || strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+			continue
+		}
+		if !filepath.IsAbs(source) {
+			source = filepath.Join(cwd, source)
+		}
+		result = append(result, source)
+	}
+	return result
+}
+
+// mergeValues deep-merges src into dst. Maps are merged key by key while
+// lists are either replaced or appended depending on listMerge. In all other
+// cases src wins.
+func mergeValues(dst, src interface{}, listMerge string) interface{} {
+	switch s := src.(type) {
+	case map[string]interface{}:
+		d, ok := dst.(map[string]interface{})
+		if !ok {
+			return s
+		}
+		result := make(map[string]interface{}, len(d)+len(s))
+		for k, v := range d {
+			result[k] = v
+		}
+		for k, v := range s {
+			result[k] = mergeValues(d[k], v, listMerge)
+		}
+		return result
+	case []interface{}:
+		d, ok := dst.([]interface{})
+		if !ok || listMerge != ListMergeAppend {
+			return s
+		}
+		result := make([]interface{}, 0, len(d)+len(s))
+		result = append(result, d...)
+		return append(result, s...)
+	}
+	return src
+}
+
+// dataLoader holds the state shared between multiple data definitions like
+// age identities which should only be loaded once.
+type dataLoader struct {
+	opts       *DataOptions
+	identities []age.Identity
+	stdin      io.Reader
+	stdinRead  bool
+}
+
+// read returns the raw content of a data source which can be a file, `-`
+// for stdin, a HTTP(S) URL or a command prefixed with `exec:`.
+func (l *dataLoader) read(ctx context.Context, source string, cwd string) ([]byte, error) {
+	logger := zerolog.Ctx(ctx)
+	switch {
+	case source == "-":
+		if l.stdinRead {
+			return nil, errors.New("stdin can only be used once")
+		}
+		l.stdinRead = true
+		return ioutil.ReadAll(l.stdin)
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		logger.Debug().Msgf("Fetching data from %s", source)
+		r, err := http.NewRequest("GET", source, nil)
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to open file in `%s`", datadef)
-		}
-		switch filepath.Ext(file) {
-		case ".yaml":
-			err = loadYAMLValue(&value, fp)
-		case ".yml":
-			err = loadYAMLValue(&value, fp)
-		case ".json":
-			err = json.NewDecoder(fp).Decode(&value)
-		default:
-			return nil, errors.Errorf("unsupported file-extension in `%s`", datadef)
+			return nil, errors.Wrap(err, "failed to generate request")
 		}
+		retryClient := retryablehttp.NewClient()
+		retryClient.Logger = &LeveledZerolog{logger}
+		resp, err := retryClient.StandardClient().Do(r.WithContext(ctx))
 		if err != nil {
-			return nil, errors.Wrapf(err, "data parsing failed for `%s`", datadef)
+			return nil, errors.Wrap(err, "request failed")
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return nil, errors.Errorf("request returned error, code: %v", resp.StatusCode)
+		}
+		return ioutil.ReadAll(resp.Body)
+	case strings.HasPrefix(source, dataExecPrefix):
+		if !l.opts.Insecure {
+			return nil, ErrInsecureRequired
+		}
+		cmd := strings.TrimPrefix(source, dataExecPrefix)
+		logger.Debug().Msgf("Loading data from the output of `%s`", cmd)
+		var output bytes.Buffer
+		c := exec.CommandContext(ctx, "/bin/bash", "-c", cmd)
+		c.Stdout = &output
+		c.Stderr = os.Stderr
+		if err := c.Run(); err != nil {
+			return nil, errors.Wrapf(err, "command `%s` failed", cmd)
+		}
+		return output.Bytes(), nil
+	default:
+		path := source
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(cwd, path)
 		}
-		result[key] = value
+		return ioutil.ReadFile(path)
 	}
-	return result, nil
+}
+
+// sourceName returns the name used to determine the format of a data source
+// if no explicit format was specified. Content coming from stdin or commands
+// is treated as YAML (and therefore also JSON).
+func sourceName(source string) string {
+	switch {
+	case source == "-" || strings.HasPrefix(source, dataExecPrefix):
+		return "data.yaml"
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		if u, err := url.Parse(source); err == nil {
+			if filepath.Ext(u.Path) != "" {
+				return u.Path
+			}
+		}
+		return "data.yaml"
+	}
+	return source
+}
+
+// load reads, decrypts and decodes a single data source. The label is used
+// in error messages.
+func (l *dataLoader) load(ctx context.Context, rawSource string, cwd string, label string) (interface{}, error) {
+	source, format := splitFormat(rawSource)
+	content, err := l.read(ctx, source, cwd)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load data in `%s`", label)
+	}
+	name := sourceName(source)
+	if filepath.Ext(name) == ".age" || looksLikeAge(content) {
+		content, err = l.decryptAge(content)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decryption failed for `%s`", label)
+		}
+		name = strings.TrimSuffix(name, ".age")
+	}
+	value, err := l.decode(content, format, name)
+	if err == errUnsupportedFormat {
+		return nil, errors.Errorf("unsupported file-extension in `%s`", label)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "data parsing failed for `%s`", label)
+	}
+	if isSOPSDocument(value) {
+		value, err = l.decryptSOPS(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decryption failed for `%s`", label)
+		}
+	}
+	return value, nil
+}
+
+// decode works like the package level decode but expands the variables in
+// dotenv files using the configured environment.
+func (l *dataLoader) decode(content []byte, format, name string) (interface{}, error) {
+	if dataFormat(format, name) != "dotenv" {
+		return decode(content, format, name)
+	}
+	env, err := ParseDotenv(content, l.lookupEnv())
+	if err != nil {
+		return nil, err
+	}
+	return dotenvValue(env), nil
+}
+
+func (l *dataLoader) lookupEnv() func(string) (string, bool) {
+	if l.opts.LookupEnv != nil {
+		return l.opts.LookupEnv
+	}
+	return os.LookupEnv
+}
+
+func (l *dataLoader) ageIdentities() ([]age.Identity, error) {
+	if l.identities != nil {
+		return l.identities, nil
+	}
+	lookup := l.lookupEnv()
+	getenv := func(name string) string {
+		value, _ := lookup(name)
+		return value
+	}
+	ids, err := ageIdentities(l.opts.AgeIdentities, getenv)
+	if err != nil {
+		return nil, err
+	}
+	l.identities = ids
+	return ids, nil
+}
+
+func (l *dataLoader) decryptAge(content []byte) ([]byte, error) {
+	ids, err := l.ageIdentities()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := decryptAge(bytes.NewReader(content), ids)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(plain)
+}
+
+func (l *dataLoader) decryptSOPS(value interface{}) (interface{}, error) {
+	ids, err := l.ageIdentities()
+	if err != nil {
+		return nil, err
+	}
+	return decryptSOPS(value, ids)
 }
 
 func loadYAMLValue(out *interface{}, fp io.Reader) error {
@@ -3,6 +3,10 @@ package world_test
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -23,3 +27,162 @@ func TestData(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "> 1\n> 2\n> 3\n", out.String())
 }
+
+func TestDataSources(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config.json":
+			fmt.Fprint(w, `{"name": "from-json"}`)
+		case "/config":
+			fmt.Fprint(w, "name = \"from-toml\"\n")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		datadef  string
+		opts     world.DataOptions
+		expected interface{}
+		errored  bool
+	}{
+		{
+			name:     "stdin",
+			datadef:  "d=-",
+			opts:     world.DataOptions{Stdin: strings.NewReader("name: from-stdin\n")},
+			expected: map[string]interface{}{"name": "from-stdin"},
+		},
+		{
+			name:     "stdin-with-format",
+			datadef:  "d=-:csv",
+			opts:     world.DataOptions{Stdin: strings.NewReader("name\nfrom-csv\n")},
+			expected: []interface{}{map[string]interface{}{"name": "from-csv"}},
+		},
+		{
+			name:     "url",
+			datadef:  "d=" + srv.URL + "/config.json",
+			expected: map[string]interface{}{"name": "from-json"},
+		},
+		{
+			name:     "url-with-format",
+			datadef:  "d=" + srv.URL + "/config:toml",
+			expected: map[string]interface{}{"name": "from-toml"},
+		},
+		{
+			name:    "url-not-found",
+			datadef: "d=" + srv.URL + "/missing.json",
+			errored: true,
+		},
+		{
+			name:     "exec",
+			datadef:  `d=exec:echo '{"name": "from-exec"}':json`,
+			opts:     world.DataOptions{Insecure: true},
+			expected: map[string]interface{}{"name": "from-exec"},
+		},
+		{
+			name:    "exec-requires-insecure",
+			datadef: `d=exec:echo '{"name": "from-exec"}'`,
+			errored: true,
+		},
+		{
+			name:    "exec-failing",
+			datadef: "d=exec:exit 1",
+			opts:    world.DataOptions{Insecure: true},
+			errored: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := test.opts
+			data, err := world.LoadDataWithOptions(context.Background(), []string{test.datadef}, "../../testdata", &opts)
+			if test.errored {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.expected, data["d"])
+		})
+	}
+
+	t.Run("stdin-only-once", func(t *testing.T) {
+		_, err := world.LoadDataWithOptions(context.Background(), []string{"a=-", "b=-"}, "", &world.DataOptions{Stdin: strings.NewReader("{}")})
+		require.Error(t, err)
+	})
+}
+
+func TestDataMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		datadefs []string
+		opts     world.DataOptions
+		expected world.Data
+		errored  bool
+	}{
+		{
+			name:     "replace-lists",
+			datadefs: []string{"cfg=base.yaml", "cfg=prod.yaml"},
+			expected: world.Data{"cfg": map[string]interface{}{
+				"db":      map[string]interface{}{"host": "db.prod", "port": 5432},
+				"servers": []interface{}{"b"},
+			}},
+		},
+		{
+			name:     "append-lists",
+			datadefs: []string{"cfg=base.yaml", "cfg=prod.yaml"},
+			opts:     world.DataOptions{ListMerge: world.ListMergeAppend},
+			expected: world.Data{"cfg": map[string]interface{}{
+				"db":      map[string]interface{}{"host": "db.prod", "port": 5432},
+				"servers": []interface{}{"a", "b"},
+			}},
+		},
+		{
+			name:     "root",
+			datadefs: []string{"items=test.yaml"},
+			opts:     world.DataOptions{Root: []string{"base.yaml", "prod.yaml"}},
+			expected: world.Data{
+				"db":      map[string]interface{}{"host": "db.prod", "port": 5432},
+				"servers": []interface{}{"b"},
+				"items":   []interface{}{1, 2, 3},
+			},
+		},
+		{
+			name:    "root-must-be-map",
+			opts:    world.DataOptions{Root: []string{"test.yaml"}},
+			errored: true,
+		},
+		{
+			name:     "unknown-list-merge",
+			datadefs: []string{"cfg=base.yaml"},
+			opts:     world.DataOptions{ListMerge: "shuffle"},
+			errored:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := test.opts
+			data, err := world.LoadDataWithOptions(context.Background(), test.datadefs, "../../testdata", &opts)
+			if test.errored {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.expected, data)
+		})
+	}
+}
+
+func TestDataFiles(t *testing.T) {
+	files := world.DataFiles([]string{
+		"a=base.yaml",
+		"b=/etc/app.json:json",
+		"c=-",
+		"d=https://example.com/data.yaml",
+		"e=exec:echo '{}'",
+	}, "/data", &world.DataOptions{
+		Root:    []string{"values.yaml"},
+		SetFile: []string{"cert=ca.pem"},
+	})
+	require.Equal(t, []string{"/data/values.yaml", "/data/base.yaml", "/etc/app.json", "/data/ca.pem"}, files)
+}
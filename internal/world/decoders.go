@@ -0,0 +1,319 @@
+package world
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hashicorp/hcl"
+	"github.com/pkg/errors"
+)
+
+// Decoder converts the content of a data file into a value made up of
+// map[string]interface{}, []interface{} and scalar values.
+type Decoder func(content []byte) (interface{}, error)
+
+var errUnsupportedFormat = errors.New("unsupported data format")
+
+var decoders = map[string]Decoder{}
+var decoderExtensions = map[string]string{}
+
+func init() {
+	RegisterDecoder("json", decodeJSON, ".json")
+	RegisterDecoder("yaml", decodeYAML, ".yaml", ".yml")
+	RegisterDecoder("toml", decodeTOML, ".toml")
+	RegisterDecoder("hcl", decodeHCL, ".hcl", ".tfvars")
+	RegisterDecoder("ini", decodeINI, ".ini")
+	RegisterDecoder("dotenv", decodeDotenv, ".env")
+	RegisterDecoder("csv", decodeCSV, ".csv")
+	RegisterDecoder("xml", decodeXML, ".xml")
+}
+
+// RegisterDecoder makes a decoder available under the given format name
+// (which can be used to explicitly select it using --data=key=file:format)
+// and for all files with one of the given extensions.
+func RegisterDecoder(format string, decoder Decoder, extensions ...string) {
+	decoders[format] = decoder
+	for _, ext := range extensions {
+		decoderExtensions[ext] = format
+	}
+}
+
+// UnregisterDecoder removes the decoder registered for the given format
+// together with all of its extensions.
+func UnregisterDecoder(format string) {
+	delete(decoders, format)
+	for ext, f := range decoderExtensions {
+		if f == format {
+			delete(decoderExtensions, ext)
+		}
+	}
+}
+
+// DataFormats returns the sorted names of all registered data formats.
+func DataFormats() []string {
+	result := make([]string, 0, len(decoders))
+	for format := range decoders {
+		result = append(result, format)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// splitFormat removes an explicit format suffix (e.g. `:csv`) from a data
+// source if the suffix names a registered format.
+func splitFormat(source string) (string, string) {
+	idx := strings.LastIndex(source, ":")
+	if idx == -1 {
+		return source, ""
+	}
+	if _, ok := decoders[source[idx+1:]]; !ok {
+		return source, ""
+	}
+	return source[:idx], source[idx+1:]
+}
+
+// dataFormat returns format or, if it is not set, the format registered for
+// the extension of name.
+func dataFormat(format, name string) string {
+	if format == "" {
+		return decoderExtensions[filepath.Ext(name)]
+	}
+	return format
+}
+
+// decode converts the content using the given format or, if no format is
+// set, the decoder registered for the extension of name.
+func decode(content []byte, format, name string) (interface{}, error) {
+	decoder, ok := decoders[dataFormat(format, name)]
+	if !ok {
+		return nil, errUnsupportedFormat
+	}
+	value, err := decoder(content)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeValue(value), nil
+}
+
+func decodeJSON(content []byte) (interface{}, error) {
+	var value interface{}
+	err := json.Unmarshal(content, &value)
+	return value, err
+}
+
+func decodeYAML(content []byte) (interface{}, error) {
+	var value interface{}
+	err := loadYAMLValue(&value, bytes.NewReader(content))
+	return value, err
+}
+
+func decodeTOML(content []byte) (interface{}, error) {
+	var value map[string]interface{}
+	err := toml.Unmarshal(content, &value)
+	return value, err
+}
+
+func decodeHCL(content []byte) (interface{}, error) {
+	var value map[string]interface{}
+	err := hcl.Unmarshal(content, &value)
+	return value, err
+}
+
+// decodeDotenv only expands variables defined in the file itself. Data files
+// are decoded by dataLoader.decode instead which has access to the
+// configured environment.
+func decodeDotenv(content []byte) (interface{}, error) {
+	env, err := ParseDotenv(content, nil)
+	if err != nil {
+		return nil, err
+	}
+	return dotenvValue(env), nil
+}
+
+func dotenvValue(env map[string]string) map[string]interface{} {
+	result := make(map[string]interface{}, len(env))
+	for k, v := range env {
+		result[k] = v
+	}
+	return result
+}
+
+// decodeINI returns a map of sections. Keys defined before the first section
+// are added to the top-level map.
+func decodeINI(content []byte) (interface{}, error) {
+	result := make(map[string]interface{})
+	current := result
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, errors.Errorf("line %d: invalid section header", lineno)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			section, ok := result[name].(map[string]interface{})
+			if !ok {
+				section = make(map[string]interface{})
+				result[name] = section
+			}
+			current = section
+			continue
+		}
+		elems := strings.SplitN(line, "=", 2)
+		if len(elems) != 2 {
+			elems = strings.SplitN(line, ":", 2)
+		}
+		if len(elems) != 2 {
+			return nil, errors.Errorf("line %d: expected key=value", lineno)
+		}
+		value := strings.TrimSpace(elems[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		current[strings.TrimSpace(elems[0])] = value
+	}
+	return result, scanner.Err()
+}
+
+// decodeCSV returns a list of rows, each being a map from the column names
+// in the first row to the values of that row.
+func decodeCSV(content []byte) (interface{}, error) {
+	reader := csv.NewReader(bytes.NewReader(content))
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, 0, len(records))
+	if len(records) == 0 {
+		return result, nil
+	}
+	header := records[0]
+	for _, record := range records[1:] {
+		row := make(map[string]interface{}, len(header))
+		for i, column := range header {
+			row[column] = record[i]
+		}
+		result = append(result, row)
+	}
+	return result, nil
+}
+
+// decodeXML converts an XML document into nested maps: every element
+// becomes a map containing its attributes (prefixed with `_`) and child
+// elements. Elements occurring multiple times become lists and elements
+// with neither attributes nor children are represented by their text.
+func decodeXML(content []byte) (interface{}, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			return nil, errors.New("no root element found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			value, err := decodeXMLElement(decoder, start)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{start.Name.Local: value}, nil
+		}
+	}
+}
+
+func decodeXMLElement(decoder *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	result := make(map[string]interface{})
+	for _, attr := range start.Attr {
+		result["_"+attr.Name.Local] = attr.Value
+	}
+	var text strings.Builder
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := decodeXMLElement(decoder, t)
+			if err != nil {
+				return nil, err
+			}
+			name := t.Name.Local
+			switch existing := result[name].(type) {
+			case nil:
+				result[name] = child
+			case []interface{}:
+				result[name] = append(existing, child)
+			default:
+				result[name] = []interface{}{existing, child}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			trimmed := strings.TrimSpace(text.String())
+			if len(result) == 0 {
+				return trimmed, nil
+			}
+			if trimmed != "" {
+				result["_text"] = trimmed
+			}
+			return result, nil
+		}
+	}
+}
+
+// mapValue looks up a key in a map regardless of whether it was decoded from
+// JSON or YAML.
+func mapValue(value interface{}, key string) (interface{}, bool) {
+	switch m := value.(type) {
+	case map[string]interface{}:
+		v, ok := m[key]
+		return v, ok
+	case map[interface{}]interface{}:
+		v, ok := m[key]
+		return v, ok
+	}
+	return nil, false
+}
+
+// normalizeValue converts the map[interface{}]interface{} values produced by
+// the YAML decoder into map[string]interface{} so that all data formats
+// share the same structure.
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			result[fmt.Sprintf("%v", k)] = normalizeValue(item)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			result[k] = normalizeValue(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = normalizeValue(item)
+		}
+		return result
+	}
+	return value
+}
@@ -0,0 +1,120 @@
+package world_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zerok/tpl/internal/world"
+)
+
+func TestDataFormats(t *testing.T) {
+	tests := []struct {
+		datadef  string
+		expected interface{}
+	}{
+		{
+			datadef: "d=hosts.csv",
+			expected: []interface{}{
+				map[string]interface{}{"name": "web-1", "ip": "10.0.0.1"},
+				map[string]interface{}{"name": "web-2", "ip": "10.0.0.2"},
+			},
+		},
+		{
+			datadef: "d=inventory.txt:csv",
+			expected: []interface{}{
+				map[string]interface{}{"name": "web-1", "ip": "10.0.0.1"},
+				map[string]interface{}{"name": "web-2", "ip": "10.0.0.2"},
+			},
+		},
+		{
+			datadef: "d=app.toml",
+			expected: map[string]interface{}{
+				"name":     "app",
+				"replicas": int64(3),
+				"database": map[string]interface{}{"host": "db.internal", "port": int64(5432)},
+			},
+		},
+		{
+			datadef: "d=vars.tfvars",
+			expected: map[string]interface{}{
+				"region": "eu-central-1",
+				"zones":  []interface{}{"a", "b"},
+			},
+		},
+		{
+			datadef: "d=app.ini",
+			expected: map[string]interface{}{
+				"debug":    "false",
+				"database": map[string]interface{}{"host": "db.internal", "user": "app"},
+			},
+		},
+		{
+			datadef: "d=app.env",
+			expected: map[string]interface{}{
+				"NAME":     "app",
+				"GREETING": "hello app\n",
+				"LITERAL":  "${NAME}",
+			},
+		},
+		{
+			datadef: "d=inventory.xml",
+			expected: map[string]interface{}{
+				"inventory": map[string]interface{}{
+					"_region": "eu",
+					"host": []interface{}{
+						map[string]interface{}{"_name": "web-1", "_text": "10.0.0.1"},
+						map[string]interface{}{"_name": "web-2", "_text": "10.0.0.2"},
+					},
+					"owner": "ops",
+				},
+			},
+		},
+		{
+			datadef:  "d=test.yaml",
+			expected: []interface{}{1, 2, 3},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.datadef, func(t *testing.T) {
+			data, err := world.LoadData(context.Background(), []string{test.datadef}, "../../testdata")
+			require.NoError(t, err)
+			require.Equal(t, test.expected, data["d"])
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := world.LoadData(context.Background(), []string{"d=inventory.txt"}, "../../testdata")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unsupported file-extension")
+	})
+
+	t.Run("custom-decoder", func(t *testing.T) {
+		world.RegisterDecoder("lines", func(content []byte) (interface{}, error) {
+			return []interface{}{string(content)}, nil
+		}, ".lines")
+		t.Cleanup(func() { world.UnregisterDecoder("lines") })
+		require.Contains(t, world.DataFormats(), "lines")
+		data, err := world.LoadData(context.Background(), []string{"d=test.yaml:lines"}, "../../testdata")
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{"- 1\n- 2\n- 3"}, data["d"])
+	})
+
+	t.Run("dotenv-expansion", func(t *testing.T) {
+		t.Setenv("TPL_DOTENV_HOST", "process")
+		dir := t.TempDir()
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "app.env"), []byte("URL=http://${TPL_DOTENV_HOST}/\n"), 0600))
+		load := func(lookup func(string) (string, bool)) interface{} {
+			data, err := world.LoadDataWithOptions(context.Background(), []string{"d=app.env"}, dir, &world.DataOptions{LookupEnv: lookup})
+			require.NoError(t, err)
+			return data["d"]
+		}
+		require.Equal(t, map[string]interface{}{"URL": "http://process/"}, load(nil))
+		require.Equal(t, map[string]interface{}{"URL": "http://file/"}, load(func(name string) (string, bool) {
+			return map[string]string{"TPL_DOTENV_HOST": "file"}[name], name == "TPL_DOTENV_HOST"
+		}))
+		require.Equal(t, map[string]interface{}{"URL": "http:///"}, load(func(string) (string, bool) { return "", false }))
+	})
+}
@@ -0,0 +1,231 @@
+package world
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template/parse"
+
+	"github.com/pkg/errors"
+)
+
+// Dependencies lists everything a template refers to as far as it can be
+// determined without executing it.
+type Dependencies struct {
+	Secrets  []SecretDependency `json:"secrets"`
+	Env      []Dependency       `json:"env"`
+	Data     []Dependency       `json:"data"`
+	Files    []Dependency       `json:"files"`
+	Commands []Dependency       `json:"commands"`
+}
+
+// Dependency is a single environment variable, data key, file or command.
+// If the name depends on values only known while rendering, Dynamic is set
+// and Expression contains the part of the template it is used in.
+type Dependency struct {
+	Name       string `json:"name,omitempty"`
+	Dynamic    bool   `json:"dynamic,omitempty"`
+	Expression string `json:"expression,omitempty"`
+	Location   string `json:"location,omitempty"`
+}
+
+// SecretDependency is a secret referenced by a template.
+type SecretDependency struct {
+	Backend string `json:"backend,omitempty"`
+	Path    string `json:"path,omitempty"`
+
+	// ResolvedPath is the path after applying prefix and key mapping.
+	ResolvedPath string `json:"resolvedPath,omitempty"`
+
+	Field      string `json:"field,omitempty"`
+	Version    string `json:"version,omitempty"`
+	Dynamic    bool   `json:"dynamic,omitempty"`
+	Expression string `json:"expression,omitempty"`
+	Location   string `json:"location,omitempty"`
+}
+
+// envFuncNames are the template functions whose first argument is the name
+// of an environment variable.
+var envFuncNames = map[string]struct{}{
+	"env":        {},
+	"envOr":      {},
+	"requireEnv": {},
+	"envBool":    {},
+	"envInt":     {},
+}
+
+// Dependencies parses the template together with its partials and includes
+// and lists everything it refers to. The template is not executed. path is
+// used to resolve relative includes like in RenderTemplate. Files are listed
+// with their absolute paths.
+func (w *World) Dependencies(in io.Reader, path string) (*Dependencies, error) {
+	rawTmpl, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read template")
+	}
+	if path != "" {
+		w.recordFile(path, FileAccessTemplate)
+	}
+	set, err := w.newTemplateSet(string(rawTmpl), path)
+	if err != nil {
+		return nil, err
+	}
+	deps := &Dependencies{}
+	seen := make(map[string]struct{})
+	// add returns false for dependencies that are already known. Dynamic
+	// ones are kept per location.
+	add := func(kind, name string, dep Dependency) bool {
+		key := kind + "|" + name
+		if dep.Dynamic {
+			key += "|" + dep.Location + "|" + dep.Expression
+		}
+		if _, ok := seen[key]; ok {
+			return false
+		}
+		seen[key] = struct{}{}
+		return true
+	}
+
+	walkCalls(set.tmpl, func(c templateCall) {
+		dynamic := Dependency{Dynamic: true, Expression: c.cmd.String(), Location: c.location()}
+		if scheme, ok := secretFuncs[c.name]; ok {
+			call, ok := constantSecretCall(c)
+			if !ok {
+				if add("secret", scheme, dynamic) {
+					deps.Secrets = append(deps.Secrets, SecretDependency{
+						Backend:    scheme,
+						Dynamic:    true,
+						Expression: dynamic.Expression,
+						Location:   dynamic.Location,
+					})
+				}
+				return
+			}
+			if add("secret", call.scheme+"://"+call.ref.String(), Dependency{}) {
+				deps.Secrets = append(deps.Secrets, SecretDependency{
+					Backend:      call.scheme,
+					Path:         call.ref.Path,
+					ResolvedPath: w.resolveSecretPath(call.scheme, call.ref.Path),
+					Field:        call.ref.Field,
+					Version:      call.ref.Version,
+					Location:     c.location(),
+				})
+			}
+			return
+		}
+		var kind string
+		var list *[]Dependency
+		switch {
+		case c.name == "FS.ReadFile" || c.name == "FS.Exists":
+			kind, list = "file", &deps.Files
+		case c.name == "System.ShellOutput":
+			kind, list = "command", &deps.Commands
+		default:
+			if _, ok := envFuncNames[c.name]; !ok {
+				return
+			}
+			kind, list = "env", &deps.Env
+		}
+		var name string
+		if !c.piped && !c.rebound && len(c.args) > 0 {
+			if s, ok := c.args[0].(*parse.StringNode); ok {
+				name = s.Text
+			}
+		}
+		if name == "" {
+			if add(kind, "", dynamic) {
+				*list = append(*list, dynamic)
+			}
+			return
+		}
+		if kind == "file" {
+			if abs, err := filepath.Abs(name); err == nil {
+				name = abs
+			}
+		}
+		if add(kind, name, Dependency{}) {
+			*list = append(*list, Dependency{Name: name, Location: c.location()})
+		}
+	})
+
+	walkFields(set.tmpl, func(ident []string, node parse.Node, tree *parse.Tree) {
+		var kind string
+		var list *[]Dependency
+		switch ident[0] {
+		case "Env":
+			kind, list = "env", &deps.Env
+		case "Data":
+			kind, list = "data", &deps.Data
+		default:
+			return
+		}
+		location := nodeLocation(tree, node)
+		if len(ident) == 1 {
+			dep := Dependency{Dynamic: true, Expression: node.String(), Location: location}
+			if add(kind, "", dep) {
+				*list = append(*list, dep)
+			}
+			return
+		}
+		name := ident[1]
+		if kind == "data" {
+			name = strings.Join(ident[1:], ".")
+		}
+		if add(kind, name, Dependency{}) {
+			*list = append(*list, Dependency{Name: name, Location: location})
+		}
+	})
+
+	for _, file := range w.Files() {
+		if add("file", file, Dependency{}) {
+			deps.Files = append(deps.Files, Dependency{Name: file})
+		}
+	}
+
+	sortDependencies(deps.Env)
+	sortDependencies(deps.Data)
+	sortDependencies(deps.Files)
+	sortDependencies(deps.Commands)
+	sort.SliceStable(deps.Secrets, func(i, j int) bool {
+		a, b := deps.Secrets[i], deps.Secrets[j]
+		if a.Dynamic != b.Dynamic {
+			return !a.Dynamic
+		}
+		if a.Backend != b.Backend {
+			return a.Backend < b.Backend
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Field != b.Field {
+			return a.Field < b.Field
+		}
+		return a.Version < b.Version
+	})
+	return deps, nil
+}
+
+// sortDependencies sorts by name and lists dynamic dependencies last.
+func sortDependencies(deps []Dependency) {
+	sort.SliceStable(deps, func(i, j int) bool {
+		if deps[i].Dynamic != deps[j].Dynamic {
+			return !deps[i].Dynamic
+		}
+		return deps[i].Name < deps[j].Name
+	})
+}
+
+// resolveSecretPath applies the path mapping of the provider registered for
+// scheme without retrieving anything.
+func (w *World) resolveSecretPath(scheme, path string) string {
+	p, err := w.secretProvider(scheme)
+	if err != nil {
+		return path
+	}
+	if m, ok := p.(pathMapper); ok {
+		return m.Mapping().MapPath(path)
+	}
+	return path
+}
@@ -0,0 +1,71 @@
+package world_test
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zerok/tpl/internal/world"
+)
+
+func TestDependencies(t *testing.T) {
+	w := world.New(context.Background(), &world.Options{
+		Partials: []string{"../../testdata/partials/env.tpl"},
+	})
+	p := &fakeProvider{}
+	p.Prefix = "prod/"
+	w.RegisterSecretProvider("vault", func() world.SecretProvider { return p })
+
+	tmpl := `{{ vault "secret/app" "password" }} {{ vault "secret/app" "password" }}
+{{ secret "azure://db-pass" }} {{ .Azure.Secret "other" }}
+{{ $path := "x" }}{{ vault $path "password" }}
+{{ .Env.HOME }} {{ env "USER" }} {{ index .Env "SHELL" }} {{ "LANG" | env }}
+{{ .Data.app.name }} {{ index .Data "db" "host" }} {{ range .Data.servers }}{{ .name }}{{ end }} {{ toJson .Data }}
+{{ .FS.ReadFile "config.txt" }} {{ .System.ShellOutput "hostname" }}
+{{ template "../../testdata/partials/env.tpl" }}`
+	deps, err := w.Dependencies(bytes.NewBufferString(tmpl), "")
+	require.NoError(t, err)
+	require.Equal(t, []world.SecretDependency{
+		{Backend: "azure", Path: "db-pass", ResolvedPath: "db-pass", Location: "ROOT:2:3"},
+		{Backend: "azure", Path: "other", ResolvedPath: "other", Location: "ROOT:2:34"},
+		{Backend: "vault", Path: "secret/app", ResolvedPath: "prod/secret/app", Field: "password", Location: "ROOT:1:3"},
+		{Backend: "vault", Dynamic: true, Expression: `vault $path "password"`, Location: "ROOT:3:21"},
+	}, deps.Secrets)
+	names := func(deps []world.Dependency) []string {
+		var result []string
+		for _, dep := range deps {
+			if dep.Dynamic {
+				result = append(result, "dynamic: "+dep.Expression)
+			} else {
+				result = append(result, dep.Name)
+			}
+		}
+		return result
+	}
+	require.Equal(t, []string{"HOME", "SHELL", "USER", "dynamic: env"}, names(deps.Env))
+	require.Equal(t, []string{"app.name", "db.host", "servers", "dynamic: .Data"}, names(deps.Data))
+	config, _ := filepath.Abs("config.txt")
+	partial, _ := filepath.Abs("../../testdata/partials/env.tpl")
+	require.Equal(t, []string{config, partial}, names(deps.Files))
+	require.Equal(t, []string{"hostname"}, names(deps.Commands))
+	require.Equal(t, 0, p.calls)
+}
+
+func TestDependenciesReboundDot(t *testing.T) {
+	w := world.New(context.Background(), nil)
+	tmpl := `{{ range .Data.items }}{{ .Vault.Secret "secret/item" "password" }}{{ $.Vault.Secret "secret/app" "password" }}{{ vault "secret/fn" "password" }}{{ end }}
+{{ with .Data.app }}{{ .Azure.Secret "item" }}{{ .FS.ReadFile "item.txt" }}{{ else }}{{ .Azure.Secret "db" }}{{ end }}`
+	deps, err := w.Dependencies(bytes.NewBufferString(tmpl), "")
+	require.NoError(t, err)
+	require.Equal(t, []world.SecretDependency{
+		{Backend: "azure", Path: "db", ResolvedPath: "db", Location: "ROOT:2:88"},
+		{Backend: "vault", Path: "secret/app", ResolvedPath: "secret/app", Field: "password", Location: "ROOT:1:70"},
+		{Backend: "vault", Path: "secret/fn", ResolvedPath: "secret/fn", Field: "password", Location: "ROOT:1:114"},
+		{Backend: "azure", Dynamic: true, Expression: `.Azure.Secret "item"`, Location: "ROOT:2:23"},
+		{Backend: "vault", Dynamic: true, Expression: `.Vault.Secret "secret/item" "password"`, Location: "ROOT:1:26"},
+	}, deps.Secrets)
+	require.Len(t, deps.Files, 1)
+	require.True(t, deps.Files[0].Dynamic)
+}
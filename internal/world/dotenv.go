@@ -0,0 +1,179 @@
+package world
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParseDotenv parses the content of a .env file. Lines can optionally be
+// prefixed with `export`, values can be single- or double-quoted and ${VAR}
+// or $VAR references are expanded using the variables defined earlier in
+// the same file or, if not found there, the given lookup function.
+func ParseDotenv(content []byte, lookup func(string) (string, bool)) (map[string]string, error) {
+	result := make(map[string]string)
+	expand := func(name string) string {
+		if v, ok := result[name]; ok {
+			return v
+		}
+		if lookup != nil {
+			if v, ok := lookup(name); ok {
+				return v
+			}
+		}
+		return ""
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "export ") {
+			line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		}
+		elems := strings.SplitN(line, "=", 2)
+		if len(elems) != 2 {
+			return nil, errors.Errorf("line %d: expected KEY=VALUE", lineno)
+		}
+		key := strings.TrimSpace(elems[0])
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, errors.Errorf("line %d: invalid variable name `%s`", lineno, key)
+		}
+		value, err := parseDotenvValue(strings.TrimSpace(elems[1]), expand)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", lineno)
+		}
+		result[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func parseDotenvValue(raw string, expand func(string) string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	switch raw[0] {
+	case '\'':
+		end := strings.Index(raw[1:], "'")
+		if end == -1 {
+			return "", errors.New("unterminated single-quoted value")
+		}
+		return raw[1 : end+1], nil
+	case '"':
+		var value strings.Builder
+		for i := 1; i < len(raw); i++ {
+			c := raw[i]
+			switch {
+			case c == '"':
+				return expandDotenv(value.String(), expand), nil
+			case c == '\\' && i+1 < len(raw):
+				i++
+				switch raw[i] {
+				case 'n':
+					value.WriteByte('\n')
+				case 't':
+					value.WriteByte('\t')
+				case 'r':
+					value.WriteByte('\r')
+				default:
+					value.WriteByte(raw[i])
+				}
+			default:
+				value.WriteByte(c)
+			}
+		}
+		return "", errors.New("unterminated double-quoted value")
+	}
+	if idx := strings.Index(raw, " #"); idx != -1 {
+		raw = strings.TrimSpace(raw[:idx])
+	}
+	return expandDotenv(raw, expand), nil
+}
+
+// expandDotenv replaces ${VAR} and $VAR references. ${VAR:-default} falls
+// back to the default if VAR is empty.
+func expandDotenv(value string, expand func(string) string) string {
+	if !strings.Contains(value, "$") {
+		return value
+	}
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 == len(value) {
+			out.WriteByte(value[i])
+			continue
+		}
+		if value[i+1] == '{' {
+			end := strings.Index(value[i:], "}")
+			if end == -1 {
+				out.WriteString(value[i:])
+				break
+			}
+			expr := value[i+2 : i+end]
+			name, def := expr, ""
+			if idx := strings.Index(expr, ":-"); idx != -1 {
+				name, def = expr[:idx], expr[idx+2:]
+			}
+			v := expand(name)
+			if v == "" {
+				v = def
+			}
+			out.WriteString(v)
+			i += end
+			continue
+		}
+		j := i + 1
+		for j < len(value) && isEnvNameChar(value[j]) {
+			j++
+		}
+		if j == i+1 {
+			out.WriteByte(value[i])
+			continue
+		}
+		out.WriteString(expand(value[i+1 : j]))
+		i = j - 1
+	}
+	return out.String()
+}
+
+func isEnvNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// LoadEnvFiles parses the given .env files in order. Later files override
+// variables of earlier ones and can reference them. Variables not defined in
+// any of the files are expanded using lookup.
+func LoadEnvFiles(paths []string, lookup func(string) (string, bool)) (map[string]string, error) {
+	result := make(map[string]string)
+	chained := func(name string) (string, bool) {
+		if v, ok := result[name]; ok {
+			return v, true
+		}
+		if lookup != nil {
+			return lookup(name)
+		}
+		return "", false
+	}
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read env file %s", path)
+		}
+		env, err := ParseDotenv(content, chained)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse env file %s", path)
+		}
+		for k, v := range env {
+			result[k] = v
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,61 @@
+package world
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDotenv(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		if name == "HOME" {
+			return "/home/test", true
+		}
+		return "", false
+	}
+	tests := []struct {
+		input    string
+		expected map[string]string
+		errored  bool
+	}{
+		{input: "A=1\nB=2", expected: map[string]string{"A": "1", "B": "2"}},
+		{input: "# comment\n\nexport A=1", expected: map[string]string{"A": "1"}},
+		{input: `A="line\nbreak"`, expected: map[string]string{"A": "line\nbreak"}},
+		{input: `A='$HOME\n'`, expected: map[string]string{"A": `$HOME\n`}},
+		{input: "A=value # trailing comment", expected: map[string]string{"A": "value"}},
+		{input: "A=$HOME/bin\nB=${A}:/usr/bin", expected: map[string]string{"A": "/home/test/bin", "B": "/home/test/bin:/usr/bin"}},
+		{input: "A=${MISSING:-fallback}", expected: map[string]string{"A": "fallback"}},
+		{input: "A", errored: true},
+		{input: `A="unterminated`, errored: true},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			env, err := ParseDotenv([]byte(test.input), lookup)
+			if test.errored {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.expected, env)
+		})
+	}
+}
+
+func TestLoadEnvFiles(t *testing.T) {
+	env, err := LoadEnvFiles([]string{"../../testdata/env/base.env", "../../testdata/env/prod.env"}, func(name string) (string, bool) {
+		if name == "DB_NAME" {
+			return "billing", true
+		}
+		return "", false
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"DB_HOST":    "db.prod",
+		"DB_PORT":    "5432",
+		"DB_URL":     "postgres://db.local/billing",
+		"VAULT_ADDR": "http://vault.local:8200",
+	}, env)
+
+	_, err = LoadEnvFiles([]string{"../../testdata/env/missing.env"}, nil)
+	require.Error(t, err)
+}
@@ -1,4 +1,94 @@
 package world
 
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
 // Env contains a mapping of all environment variables on the system.
 type Env map[string]string
+
+// lookupEnv returns the value of an environment variable as exposed through
+// Env.
+func (w *World) lookupEnv(name string) (string, bool) {
+	w.recordEnv(name)
+	value, ok := w.Env()[name]
+	return value, ok
+}
+
+func (w *World) envFuncs() map[string]interface{} {
+	return map[string]interface{}{
+		"env": func(name string) (string, error) {
+			value, ok := w.lookupEnv(name)
+			if !ok && w.strict {
+				return "", errors.Errorf("environment variable %s is not set", name)
+			}
+			return value, nil
+		},
+		"envOr": func(name, def string) string {
+			if value, ok := w.lookupEnv(name); ok && value != "" {
+				return value
+			}
+			return def
+		},
+		"requireEnv": func(name string, reason ...string) string {
+			value, ok := w.lookupEnv(name)
+			if !ok || value == "" {
+				if _, known := w.missingEnv[name]; !known || len(reason) > 0 {
+					w.missingEnv[name] = strings.Join(reason, " ")
+				}
+			}
+			return value
+		},
+		"envBool": func(name string, def ...bool) (bool, error) {
+			value, ok := w.lookupEnv(name)
+			if !ok || value == "" {
+				return len(def) > 0 && def[0], nil
+			}
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return false, errors.Errorf("environment variable %s is not a boolean: %s", name, value)
+			}
+			return b, nil
+		},
+		"envInt": func(name string, def ...int) (int, error) {
+			value, ok := w.lookupEnv(name)
+			if !ok || value == "" {
+				if len(def) > 0 {
+					return def[0], nil
+				}
+				return 0, nil
+			}
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				return 0, errors.Errorf("environment variable %s is not an integer: %s", name, value)
+			}
+			return i, nil
+		},
+	}
+}
+
+// missingEnvError reports all the variables passed to requireEnv that were
+// not set during the last render.
+func (w *World) missingEnvError() error {
+	if len(w.missingEnv) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(w.missingEnv))
+	for name := range w.missingEnv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		line := "  " + name
+		if reason := w.missingEnv[name]; reason != "" {
+			line += ": " + reason
+		}
+		lines = append(lines, line)
+	}
+	return errors.Errorf("missing required environment variables:\n%s", strings.Join(lines, "\n"))
+}
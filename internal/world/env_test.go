@@ -1,6 +1,7 @@
 package world
 
 import (
+	"bytes"
 	"context"
 	"os"
 	"testing"
@@ -17,3 +18,75 @@ func TestEnv(t *testing.T) {
 		require.Equal(t, "hello world", out)
 	})
 }
+
+func TestEnvFuncs(t *testing.T) {
+	t.Setenv("TPL_TEST_HOST", "db.local")
+	t.Setenv("TPL_TEST_PORT", "5432")
+	t.Setenv("TPL_TEST_DEBUG", "true")
+	t.Setenv("TPL_TEST_INVALID", "nope")
+	t.Setenv("TPL_TEST_UNSET", "")
+	os.Unsetenv("TPL_TEST_UNSET")
+	t.Setenv("TPL_TEST_API_KEY", "")
+	os.Unsetenv("TPL_TEST_API_KEY")
+	t.Setenv("TPL_TEST_TOKEN", "")
+	os.Unsetenv("TPL_TEST_TOKEN")
+
+	tests := []struct {
+		input  string
+		output string
+		err    string
+	}{
+		{input: `{{ env "TPL_TEST_HOST" }}`, output: "db.local"},
+		{input: `{{ env "TPL_TEST_UNSET" }}`, output: ""},
+		{input: `{{ envOr "TPL_TEST_UNSET" "8080" }}`, output: "8080"},
+		{input: `{{ envOr "TPL_TEST_PORT" "8080" }}`, output: "5432"},
+		{input: `{{ requireEnv "TPL_TEST_HOST" "needed" }}`, output: "db.local"},
+		{input: `{{ if envBool "TPL_TEST_DEBUG" }}debug{{ end }}`, output: "debug"},
+		{input: `{{ envBool "TPL_TEST_UNSET" true }}`, output: "true"},
+		{input: `{{ add (envInt "TPL_TEST_PORT") 1 }}`, output: "5433"},
+		{input: `{{ envInt "TPL_TEST_UNSET" 8080 }}`, output: "8080"},
+		{input: `{{ envInt "TPL_TEST_INVALID" }}`, err: "TPL_TEST_INVALID is not an integer"},
+		{input: `{{ envBool "TPL_TEST_INVALID" }}`, err: "TPL_TEST_INVALID is not a boolean"},
+		{
+			input: `{{ requireEnv "TPL_TEST_TOKEN" }}{{ requireEnv "TPL_TEST_API_KEY" "used to call the API" }}{{ requireEnv "TPL_TEST_TOKEN" }}`,
+			err:   "missing required environment variables:\n  TPL_TEST_API_KEY: used to call the API\n  TPL_TEST_TOKEN",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			w := New(context.Background(), &Options{})
+			var out bytes.Buffer
+			err := w.Render(&out, bytes.NewBufferString(test.input))
+			if test.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), test.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.output, out.String())
+		})
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		w := New(context.Background(), &Options{Strict: true})
+		requireError(t, w, `{{ env "TPL_TEST_UNSET" }}`)
+	})
+}
+
+func TestEnvOptions(t *testing.T) {
+	t.Setenv("TPL_TEST_PROCESS", "process")
+	t.Setenv("TPL_TEST_OVERRIDE", "process")
+	extra := map[string]string{"TPL_TEST_OVERRIDE": "file", "TPL_TEST_FILE": "file"}
+
+	t.Run("layered", func(t *testing.T) {
+		w := New(context.Background(), &Options{Env: extra})
+		out := requireRender(t, w, `{{ env "TPL_TEST_PROCESS" }} {{ env "TPL_TEST_OVERRIDE" }} {{ .Env.TPL_TEST_FILE }}`)
+		require.Equal(t, "process file file", out)
+	})
+
+	t.Run("env-only", func(t *testing.T) {
+		w := New(context.Background(), &Options{Env: extra, EnvOnly: true})
+		out := requireRender(t, w, `{{ env "TPL_TEST_PROCESS" }}|{{ env "TPL_TEST_OVERRIDE" }}`)
+		require.Equal(t, "|file", out)
+	})
+}
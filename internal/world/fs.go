@@ -3,13 +3,17 @@ package world
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 )
 
 type FS struct {
+	world *World
 }
 
 // Exists checks if a given path exists and returns true if it does.
 func (fs *FS) Exists(fpath string) bool {
+	fs.world.recordFile(fpath, FileAccessExists)
 	_, err := os.Stat(fpath)
 	if err != nil {
 		return false
@@ -19,6 +23,7 @@ func (fs *FS) Exists(fpath string) bool {
 
 // ReadFile returns the content of the given file as string.
 func (fs *FS) ReadFile(fpath string) (string, error) {
+	fs.world.recordFile(fpath, FileAccessRead)
 	fp, err := os.Open(fpath)
 	if err != nil {
 		return "", err
@@ -30,3 +35,35 @@ func (fs *FS) ReadFile(fpath string) (string, error) {
 	}
 	return string(data), nil
 }
+
+// recordFile remembers that the output depends on the given file.
+func (w *World) recordFile(fpath, access string) {
+	if w == nil {
+		return
+	}
+	if abs, err := filepath.Abs(fpath); err == nil {
+		fpath = abs
+	}
+	w.auditMu.Lock()
+	defer w.auditMu.Unlock()
+	w.audit.files[FileAccess{Path: fpath, Access: access}] = struct{}{}
+}
+
+// Files returns the sorted absolute paths of all the files that have been
+// accessed while rendering. This includes templates, partials and everything
+// accessed through FS.
+func (w *World) Files() []string {
+	w.auditMu.Lock()
+	defer w.auditMu.Unlock()
+	seen := make(map[string]struct{}, len(w.audit.files))
+	result := make([]string, 0, len(w.audit.files))
+	for access := range w.audit.files {
+		if _, ok := seen[access.Path]; ok {
+			continue
+		}
+		seen[access.Path] = struct{}{}
+		result = append(result, access.Path)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -3,6 +3,8 @@ package world
 import (
 	"bytes"
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -35,6 +37,14 @@ func TestReadFile(t *testing.T) {
 	})
 }
 
+func TestFiles(t *testing.T) {
+	w := New(context.Background(), &Options{})
+	requireRender(t, w, `{{ .FS.ReadFile "world.go" }}{{ .FS.Exists "missing-file.go" }}`)
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(wd, "missing-file.go"), filepath.Join(wd, "world.go")}, w.Files())
+}
+
 func requireRender(t *testing.T, w *World, tpl string) string {
 	var out bytes.Buffer
 	in := bytes.NewBufferString(tpl)
@@ -0,0 +1,187 @@
+package world
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/pkg/errors"
+)
+
+const includeFunc = "include"
+
+// isRelativeInclude checks if a template name references a file relative to
+// the including template.
+func isRelativeInclude(name string) bool {
+	return strings.HasPrefix(name, "./") || strings.HasPrefix(name, "../")
+}
+
+// templateSet holds all the templates available during a single render
+// together with the directory relative includes are resolved against.
+type templateSet struct {
+	world *World
+	tmpl  *template.Template
+	dir   string
+}
+
+// newTemplateSet parses the root template as well as all partials and
+// files found in the include directories.
+func (w *World) newTemplateSet(content string, path string) (*templateSet, error) {
+	dir := "."
+	if path != "" {
+		dir = filepath.Dir(path)
+	}
+	set := &templateSet{world: w, dir: dir}
+	set.tmpl = template.New("ROOT").Delims(w.leftDelim, w.rightDelim).Funcs(w.Funcs()).Funcs(template.FuncMap{
+		includeFunc: set.include,
+	})
+	if w.strict {
+		set.tmpl = set.tmpl.Option("missingkey=error")
+	}
+	if err := set.parse(set.tmpl, content, dir); err != nil {
+		return nil, errors.Wrap(err, "failed to parse template")
+	}
+	partials, err := w.partialFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, partial := range partials {
+		if set.tmpl.Lookup(partial.name) != nil {
+			continue
+		}
+		if err := set.parseFile(partial.name, partial.path); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+type partialFile struct {
+	name string
+	path string
+}
+
+// partialFiles lists all files matching the partial patterns as well as all
+// files inside the include directories. Files from include directories are
+// named after their path relative to the directory.
+func (w *World) partialFiles() ([]partialFile, error) {
+	var result []partialFile
+	for _, pattern := range w.partials {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid partials pattern `%s`", pattern)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			result = append(result, partialFile{name: filepath.ToSlash(match), path: match})
+		}
+	}
+	for _, dir := range w.includeDirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			result = append(result, partialFile{name: filepath.ToSlash(rel), path: path})
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read include directory %s", dir)
+		}
+	}
+	return result, nil
+}
+
+func (s *templateSet) parseFile(name, path string) error {
+	s.world.recordFile(path, FileAccessTemplate)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read template %s", path)
+	}
+	if err := s.parse(s.tmpl.New(name), string(content), filepath.Dir(path)); err != nil {
+		return errors.Wrapf(err, "failed to parse template %s", path)
+	}
+	return nil
+}
+
+// parse parses the content into the given template and rewrites all
+// constant relative references in {{ template }} and {{ include }} so that
+// they point to the file relative to dir. Referenced files are loaded into
+// the set.
+func (s *templateSet) parse(tmpl *template.Template, content string, dir string) error {
+	before := make(map[string]*parse.Tree)
+	for _, t := range s.tmpl.Templates() {
+		before[t.Name()] = t.Tree
+	}
+	if _, err := tmpl.Parse(content); err != nil {
+		return err
+	}
+	var referenced []string
+	for _, t := range s.tmpl.Templates() {
+		if t.Tree == nil || before[t.Name()] == t.Tree {
+			continue
+		}
+		walkNodes(t.Tree.Root, func(node parse.Node) {
+			switch n := node.(type) {
+			case *parse.TemplateNode:
+				if isRelativeInclude(n.Name) {
+					n.Name = filepath.ToSlash(filepath.Join(dir, n.Name))
+					referenced = append(referenced, n.Name)
+				}
+			case *parse.CommandNode:
+				if len(n.Args) < 2 {
+					return
+				}
+				ident, ok := n.Args[0].(*parse.IdentifierNode)
+				if !ok || ident.Ident != includeFunc {
+					return
+				}
+				if str, ok := n.Args[1].(*parse.StringNode); ok && isRelativeInclude(str.Text) {
+					str.Text = filepath.ToSlash(filepath.Join(dir, str.Text))
+					str.Quoted = `"` + str.Text + `"`
+					referenced = append(referenced, str.Text)
+				}
+			}
+		})
+	}
+	for _, name := range referenced {
+		if s.tmpl.Lookup(name) != nil {
+			continue
+		}
+		if err := s.parseFile(name, filepath.FromSlash(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// include renders the named template and returns the output as string so
+// that it can be processed further (e.g. using indent). Relative names that
+// could not be resolved while parsing are resolved against the directory of
+// the root template.
+func (s *templateSet) include(name string, data interface{}) (string, error) {
+	if isRelativeInclude(name) {
+		name = filepath.ToSlash(filepath.Join(s.dir, name))
+	}
+	if s.tmpl.Lookup(name) == nil {
+		if _, err := os.Stat(filepath.FromSlash(name)); err != nil {
+			return "", errors.Errorf("no template named `%s` found", name)
+		}
+		if err := s.parseFile(name, filepath.FromSlash(name)); err != nil {
+			return "", err
+		}
+	}
+	var out bytes.Buffer
+	if err := s.tmpl.ExecuteTemplate(&out, name, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
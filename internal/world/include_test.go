@@ -0,0 +1,53 @@
+package world
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncludes(t *testing.T) {
+	t.Run("partials", func(t *testing.T) {
+		w := New(context.Background(), &Options{
+			Partials: []string{"../../testdata/partials/*.tpl"},
+		})
+		out := requireRender(t, w, `{{ template "healthcheck" . }}`)
+		require.Equal(t, "healthcheck:\n  test: [\"CMD\", \"curl\", \"-f\", \"http://localhost\"]\n  interval: 10s", out)
+	})
+
+	t.Run("include-dir", func(t *testing.T) {
+		w := New(context.Background(), &Options{
+			IncludeDirs: []string{"../../testdata/partials"},
+		})
+		out := requireRender(t, w, `{{ include "env.tpl" (dict "port" 8080) | indent 2 }}`)
+		require.Equal(t, "  environment:\n    PORT: \"8080\"", out)
+	})
+
+	t.Run("relative-includes", func(t *testing.T) {
+		w := New(context.Background(), &Options{
+			Partials: []string{"../../testdata/partials/*.tpl"},
+		})
+		path := "../../testdata/templates/compose.tpl"
+		fp, err := os.Open(path)
+		require.NoError(t, err)
+		defer fp.Close()
+		var out bytes.Buffer
+		require.NoError(t, w.RenderTemplate(&out, fp, path))
+		require.Equal(t, `services:
+  web:
+    image: nginx
+    healthcheck:
+      test: ["CMD", "curl", "-f", "http://localhost"]
+      interval: 10s
+`, out.String())
+	})
+
+	t.Run("missing-include", func(t *testing.T) {
+		w := New(context.Background(), &Options{})
+		requireError(t, w, `{{ include (printf "%s" "missing") . }}`)
+		requireError(t, w, `{{ template "./missing.tpl" . }}`)
+	})
+}
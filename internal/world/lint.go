@@ -0,0 +1,339 @@
+package world
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template/parse"
+
+	"github.com/pkg/errors"
+)
+
+// Severities of lint issues.
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// Rules checked by Lint.
+const (
+	LintRuleParse           = "parse"
+	LintRuleUnknownFunction = "unknown-function"
+	LintRuleInsecure        = "insecure"
+	LintRuleMissingData     = "missing-data"
+	LintRuleDeprecated      = "deprecated"
+)
+
+// LintIssue is a single problem found in a template. Line and Column start
+// at 1. Column is 0 if only the line is known.
+type LintIssue struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Rule     string `json:"rule"`
+	Message  string `json:"message"`
+}
+
+// LintOptions configures the checks done by Lint.
+type LintOptions struct {
+	// CheckData reports references to keys below .Data that are not present
+	// in the world's data.
+	CheckData bool
+}
+
+// deprecatedFuncs maps deprecated template functions to their replacement.
+var deprecatedFuncs = map[string]string{
+	"jmsepathValue": "jmespathValue",
+}
+
+// builtinFuncs are the functions provided by text/template itself.
+var builtinFuncs = []string{
+	"and", "call", "html", "index", "slice", "js", "len", "not", "or",
+	"print", "printf", "println", "urlquery",
+	"eq", "ge", "gt", "le", "lt", "ne",
+}
+
+// parseErrorPattern matches the errors of text/template/parse which contain
+// the name of the template, the line and optionally the column.
+var parseErrorPattern = regexp.MustCompile(`^template: (.+?):(\d+):(?:(\d+):)? (.*)$`)
+
+// Lint checks the template for problems without rendering it. The template
+// is named after path in the reported issues. Partials and the files inside
+// the include directories are checked as well.
+func (w *World) Lint(in io.Reader, path string, opts *LintOptions) ([]LintIssue, error) {
+	if opts == nil {
+		opts = &LintOptions{}
+	}
+	rawTmpl, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read template")
+	}
+	if path == "" {
+		path = "-"
+	}
+	files := []partialFile{{name: path, path: path}}
+	partials, err := w.partialFiles()
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, partials...)
+
+	funcs := make(map[string]struct{})
+	for name := range w.Funcs() {
+		funcs[name] = struct{}{}
+	}
+	for _, name := range builtinFuncs {
+		funcs[name] = struct{}{}
+	}
+	funcs[includeFunc] = struct{}{}
+
+	leftDelim, rightDelim := w.leftDelim, w.rightDelim
+	var issues []LintIssue
+	for i, file := range files {
+		content := string(rawTmpl)
+		if i > 0 {
+			raw, err := ioutil.ReadFile(file.path)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read template %s", file.path)
+			}
+			content = string(raw)
+		}
+		tree := parse.New(file.path)
+		tree.Mode = parse.SkipFuncCheck
+		trees := make(map[string]*parse.Tree)
+		if _, err := tree.Parse(content, leftDelim, rightDelim, trees); err != nil {
+			issues = append(issues, parseErrorIssue(file.path, content, leftDelim, rightDelim, err))
+			continue
+		}
+		names := make([]string, 0, len(trees))
+		for name := range trees {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			issues = append(issues, w.lintTree(trees[name], funcs, opts)...)
+		}
+	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		a, b := issues[i], issues[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return issues, nil
+}
+
+// parseErrorIssue turns an error of text/template/parse into an issue. As
+// these errors only contain the line, the position is determined using the
+// content of the template.
+func parseErrorIssue(file, content, leftDelim, rightDelim string, err error) LintIssue {
+	issue := LintIssue{File: file, Severity: LintError, Rule: LintRuleParse, Message: err.Error()}
+	m := parseErrorPattern.FindStringSubmatch(err.Error())
+	if m == nil {
+		return issue
+	}
+	issue.Line, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		issue.Column, _ = strconv.Atoi(m[3])
+	}
+	issue.Message = m[4]
+	if issue.Column == 0 {
+		if leftDelim == "" {
+			leftDelim = "{{"
+		}
+		if rightDelim == "" {
+			rightDelim = "}}"
+		}
+		offset := parseErrorOffset(content, leftDelim, rightDelim, issue.Line, issue.Message)
+		if offset >= 0 {
+			issue.Line, issue.Column = offsetPosition(content, offset)
+		}
+	}
+	return issue
+}
+
+// parseTokenPattern matches the token parse errors complain about, e.g. "}"
+// in `unexpected "}" in operand` or end in `unexpected {{end}}`.
+var parseTokenPattern = regexp.MustCompile(`("(?:[^"\\]|\\.)*")|<(\w+)>|\{\{(\w+)\}\}`)
+
+// parseErrorOffset returns the byte offset of the problem described by msg
+// (reported at line) or -1 if it can't be determined. Actions and strings
+// that are never closed are located by scanning the content. Otherwise the
+// offending token is searched for in the actions of the line, falling back
+// to the first action of the line.
+func parseErrorOffset(content, leftDelim, rightDelim string, line int, msg string) int {
+	if strings.HasPrefix(msg, "unclosed ") || strings.HasPrefix(msg, "unterminated ") {
+		if offset := unclosedOffset(content, leftDelim, rightDelim); offset >= 0 {
+			return offset
+		}
+	}
+	if msg == "unexpected EOF" {
+		return len(content)
+	}
+	start := 0
+	for i := 1; i < line; i++ {
+		next := strings.IndexByte(content[start:], '\n')
+		if next < 0 {
+			return -1
+		}
+		start += next + 1
+	}
+	end := len(content)
+	if next := strings.IndexByte(content[start:], '\n'); next >= 0 {
+		end = start + next
+	}
+	text := content[start:end]
+	action := strings.Index(text, leftDelim)
+	if action < 0 {
+		return -1
+	}
+	if m := parseTokenPattern.FindStringSubmatch(msg); m != nil {
+		token := m[2] + m[3]
+		if m[1] != "" {
+			token, _ = strconv.Unquote(m[1])
+		}
+		if i := strings.Index(text[action+len(leftDelim):], token); token != "" && i >= 0 {
+			return start + action + len(leftDelim) + i
+		}
+	}
+	return start + action
+}
+
+// unclosedOffset returns the byte offset of the first action or the first
+// string inside of an action that is not closed or -1 if there is none.
+func unclosedOffset(content, leftDelim, rightDelim string) int {
+	pos := 0
+	for {
+		start := strings.Index(content[pos:], leftDelim)
+		if start < 0 {
+			return -1
+		}
+		start += pos
+		i := start + len(leftDelim)
+		if rest := strings.TrimLeft(content[i:], "- "); strings.HasPrefix(rest, "/*") {
+			end := strings.Index(rest, "*/")
+			if end < 0 {
+				return start
+			}
+			i = len(content) - len(rest) + end + 2
+		}
+		closed := false
+		for i < len(content) && !closed {
+			switch {
+			case strings.HasPrefix(content[i:], rightDelim):
+				pos = i + len(rightDelim)
+				closed = true
+			case content[i] == '"' || content[i] == '\'' || content[i] == '`':
+				end := closingQuote(content, i)
+				if end < 0 {
+					return i
+				}
+				i = end + 1
+			default:
+				i++
+			}
+		}
+		if !closed {
+			return start
+		}
+	}
+}
+
+// closingQuote returns the offset of the quote closing the string starting
+// at content[start] or -1. Only raw strings may span multiple lines.
+func closingQuote(content string, start int) int {
+	quote := content[start]
+	for i := start + 1; i < len(content); i++ {
+		switch {
+		case content[i] == quote:
+			return i
+		case quote == '`':
+		case content[i] == '\\':
+			i++
+		case content[i] == '\n':
+			return -1
+		}
+	}
+	return -1
+}
+
+// offsetPosition returns the line and column (both starting at 1) of the
+// byte offset in content.
+func offsetPosition(content string, offset int) (int, int) {
+	before := content[:offset]
+	return strings.Count(before, "\n") + 1, offset - strings.LastIndex(before, "\n")
+}
+
+func (w *World) lintTree(tree *parse.Tree, funcs map[string]struct{}, opts *LintOptions) []LintIssue {
+	var issues []LintIssue
+	report := func(node parse.Node, severity, rule, format string, args ...interface{}) {
+		issue := LintIssue{File: tree.ParseName, Severity: severity, Rule: rule, Message: fmt.Sprintf(format, args...)}
+		_, line, col := nodePosition(tree, node)
+		issue.Line, issue.Column = line, col+1
+		issues = append(issues, issue)
+	}
+	reported := make(map[parse.Node]bool)
+	walkNodes(tree.Root, func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				ident, ok := arg.(*parse.IdentifierNode)
+				if !ok || reported[ident] {
+					continue
+				}
+				reported[ident] = true
+				if replacement, ok := deprecatedFuncs[ident.Ident]; ok {
+					report(ident, LintWarning, LintRuleDeprecated, "%s is deprecated, use %s instead", ident.Ident, replacement)
+				} else if _, ok := funcs[ident.Ident]; !ok {
+					report(ident, LintError, LintRuleUnknownFunction, "function %q not defined", ident.Ident)
+				}
+			}
+		}
+	})
+	walkTreeFields(tree, func(ident []string, node parse.Node, _ *parse.Tree) {
+		switch {
+		case len(ident) >= 2 && ident[0] == "System" && ident[1] == "ShellOutput":
+			if !w.insecure {
+				report(node, LintError, LintRuleInsecure, ".System.ShellOutput requires --insecure")
+			}
+		case len(ident) >= 2 && ident[0] == "Data" && opts.CheckData:
+			if missing := missingDataKey(w.Data, ident[1:]); missing != "" {
+				report(node, LintWarning, LintRuleMissingData, ".Data.%s is not defined in the data files", missing)
+			}
+		}
+	})
+	return issues
+}
+
+// missingDataKey returns the part of the key path that cannot be found in
+// data (e.g. app.name if app exists but has no name). Paths going through
+// anything but maps (e.g. lists) are not checked.
+func missingDataKey(data Data, path []string) string {
+	var current interface{} = map[string]interface{}(data)
+	for i, key := range path {
+		var value interface{}
+		var ok bool
+		switch m := current.(type) {
+		case map[string]interface{}:
+			value, ok = m[key]
+		case map[interface{}]interface{}:
+			value, ok = m[key]
+		default:
+			return ""
+		}
+		if !ok {
+			return strings.Join(path[:i+1], ".")
+		}
+		current = value
+	}
+	return ""
+}
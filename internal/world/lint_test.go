@@ -0,0 +1,70 @@
+package world_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zerok/tpl/internal/world"
+)
+
+func TestLint(t *testing.T) {
+	lint := func(t *testing.T, opts *world.Options, tmpl string) []world.LintIssue {
+		w := world.New(context.Background(), opts)
+		w.Data = world.Data{"app": map[string]interface{}{"name": "web"}, "servers": []interface{}{}}
+		issues, err := w.Lint(bytes.NewBufferString(tmpl), "config.tpl", &world.LintOptions{CheckData: true})
+		require.NoError(t, err)
+		return issues
+	}
+
+	t.Run("clean", func(t *testing.T) {
+		require.Empty(t, lint(t, nil, `{{ .Data.app.name | upper }} {{ include "x" . }} {{ index .Data.servers 0 }} {{ env "HOME" }}`))
+	})
+
+	t.Run("parse-error", func(t *testing.T) {
+		issues := lint(t, &world.Options{LeftDelim: "[[", RightDelim: "]]"}, "line 1\n[[ .Data.app.name ]]\n[[ if .Data.app ]]\n{{ not an action }}")
+		require.Equal(t, []world.LintIssue{
+			{File: "config.tpl", Line: 4, Column: 20, Severity: world.LintError, Rule: world.LintRuleParse, Message: "unexpected EOF"},
+		}, issues)
+
+		tests := []struct {
+			tmpl   string
+			line   int
+			column int
+		}{
+			{tmpl: "a\n{{ if .Data }\nb\n", line: 2, column: 13},
+			{tmpl: "a\n  {{ .Data\n", line: 2, column: 3},
+			{tmpl: "a {{ .Data }} {{ print \"b }}\n", line: 1, column: 24},
+			{tmpl: "a\n  {{ end }}", line: 2, column: 6},
+			{tmpl: "a\n{{ $x }}", line: 2, column: 4},
+		}
+		for _, test := range tests {
+			issues := lint(t, nil, test.tmpl)
+			require.Len(t, issues, 1, test.tmpl)
+			require.Equal(t, test.line, issues[0].Line, test.tmpl)
+			require.Equal(t, test.column, issues[0].Column, test.tmpl)
+		}
+		issues = lint(t, &world.Options{LeftDelim: "[[", RightDelim: "]]"}, "{{ x\n[[ .Data ]] [[ .Data\n")
+		require.Equal(t, "unclosed action started at config.tpl:2", issues[0].Message)
+		require.Equal(t, 2, issues[0].Line)
+		require.Equal(t, 13, issues[0].Column)
+	})
+
+	t.Run("checks", func(t *testing.T) {
+		tmpl := `{{ .Data.app.name }} {{ .Data.app.port }} {{ index .Data "db" "host" }}
+{{ doesNotExist "x" }} {{ "{}" | jsonToMap | jmsepathValue "a" }}
+{{ .System.ShellOutput "hostname" }}`
+		issues := lint(t, nil, tmpl)
+		require.Equal(t, []world.LintIssue{
+			{File: "config.tpl", Line: 1, Column: 25, Severity: world.LintWarning, Rule: world.LintRuleMissingData, Message: ".Data.app.port is not defined in the data files"},
+			{File: "config.tpl", Line: 1, Column: 46, Severity: world.LintWarning, Rule: world.LintRuleMissingData, Message: ".Data.db is not defined in the data files"},
+			{File: "config.tpl", Line: 2, Column: 4, Severity: world.LintError, Rule: world.LintRuleUnknownFunction, Message: `function "doesNotExist" not defined`},
+			{File: "config.tpl", Line: 2, Column: 46, Severity: world.LintWarning, Rule: world.LintRuleDeprecated, Message: "jmsepathValue is deprecated, use jmespathValue instead"},
+			{File: "config.tpl", Line: 3, Column: 4, Severity: world.LintError, Rule: world.LintRuleInsecure, Message: ".System.ShellOutput requires --insecure"},
+		}, issues)
+
+		issues = lint(t, &world.Options{Insecure: true}, `{{ .System.ShellOutput "hostname" }}`)
+		require.Empty(t, issues)
+	})
+}
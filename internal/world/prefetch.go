@@ -0,0 +1,159 @@
+package world
+
+import (
+	"strconv"
+	"sync"
+	"text/template"
+	"text/template/parse"
+)
+
+// prefetchWorkers limits the number of secrets retrieved concurrently.
+const prefetchWorkers = 8
+
+// secretCall is a secret lookup found in a template.
+type secretCall struct {
+	scheme string
+	ref    SecretRef
+}
+
+// prefetchSecrets retrieves all secrets referenced with constant arguments
+// concurrently so that the render itself can be served from memory. Lookups
+// with dynamic arguments are still resolved lazily during the render.
+//
+// As the template is not executed, this may also retrieve secrets that end
+// up not being used (e.g. inside an if block). Failures are therefore not
+// reported here: they are remembered and only reported by the render if it
+// actually needs the secret, without retrieving it again. For the same
+// reason prefetched secrets only show up in the audit report once they are
+// used. Calls on a rebound dot are skipped as they may not refer to the
+// world at all.
+func (w *World) prefetchSecrets(tmpl *template.Template) {
+	w.secretMu.Lock()
+	w.secretErrors = make(map[string]error)
+	w.secretMu.Unlock()
+
+	// Lookups of the same path are handled by a single worker so that
+	// providers can serve further fields of a secret from memory.
+	var groups [][]secretCall
+	index := make(map[string]int)
+	for _, call := range secretCalls(tmpl) {
+		key := call.scheme + "://" + call.ref.Path + "?" + call.ref.Version
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], call)
+	}
+	if len(groups) == 0 {
+		return
+	}
+	workers := prefetchWorkers
+	if len(groups) < workers {
+		workers = len(groups)
+	}
+	jobs := make(chan []secretCall)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range jobs {
+				for _, call := range group {
+					w.fetchSecret(call.scheme, call.ref)
+				}
+			}
+		}()
+	}
+	for _, group := range groups {
+		jobs <- group
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// secretFuncs maps the functions and methods that retrieve secrets to the
+// scheme of their provider. The scheme of secret is part of its argument.
+var secretFuncs = map[string]string{
+	"vault":         "vault",
+	"Vault.Secret":  "vault",
+	"secret":        "",
+	"Azure.Secret":  "azure",
+	"AWS.Secret":    "aws",
+	"AWS.Parameter": "ssm",
+}
+
+// secretCalls lists the secret lookups with constant arguments in all the
+// templates of the set.
+func secretCalls(tmpl *template.Template) []secretCall {
+	var result []secretCall
+	seen := make(map[secretCall]struct{})
+	walkCalls(tmpl, func(c templateCall) {
+		call, ok := constantSecretCall(c)
+		if !ok {
+			return
+		}
+		if _, ok := seen[call]; ok {
+			return
+		}
+		seen[call] = struct{}{}
+		result = append(result, call)
+	})
+	return result
+}
+
+// constantSecretCall checks if c calls vault, secret or one of the Secret
+// methods of the world using constant arguments only. Methods called on a
+// rebound dot are never considered constant.
+func constantSecretCall(c templateCall) (secretCall, bool) {
+	var call secretCall
+	scheme, ok := secretFuncs[c.name]
+	if !ok || c.piped || c.rebound {
+		return call, false
+	}
+	args := c.args
+	switch scheme {
+	case "vault":
+		if len(args) == 3 {
+			number, ok := args[2].(*parse.NumberNode)
+			if !ok || !number.IsInt {
+				return call, false
+			}
+			call.ref.Version = strconv.Itoa(int(number.Int64))
+			args = args[:2]
+		}
+		values, ok := stringArgs(args)
+		if !ok || len(values) != 2 {
+			return call, false
+		}
+		call.ref.Path, call.ref.Field = values[0], values[1]
+	case "":
+		values, ok := stringArgs(args)
+		if !ok || len(values) != 1 {
+			return call, false
+		}
+		var err error
+		scheme, call.ref, err = ParseSecretURI(values[0])
+		if err != nil {
+			return call, false
+		}
+	case "azure", "ssm":
+		values, ok := stringArgs(args)
+		if !ok || len(values) != 1 {
+			return call, false
+		}
+		call.ref.Path = values[0]
+	case "aws":
+		values, ok := stringArgs(args)
+		if !ok || len(values) < 1 || len(values) > 2 {
+			return call, false
+		}
+		call.ref.Path = values[0]
+		if len(values) == 2 {
+			call.ref.Field = values[1]
+		}
+	}
+	call.scheme = scheme
+	return call, true
+}
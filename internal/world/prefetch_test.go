@@ -0,0 +1,111 @@
+package world_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/zerok/tpl/internal/world"
+)
+
+// slowProvider takes some time for every lookup and records how many
+// lookups were running at the same time.
+type slowProvider struct {
+	mu      sync.Mutex
+	running int
+	maxRun  int
+	calls   map[string]int
+}
+
+func (p *slowProvider) FetchSecret(ref world.SecretRef) (string, error) {
+	p.mu.Lock()
+	p.running++
+	if p.running > p.maxRun {
+		p.maxRun = p.running
+	}
+	p.calls[ref.String()]++
+	p.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	p.mu.Lock()
+	p.running--
+	p.mu.Unlock()
+	if ref.Path == "missing" {
+		return "", errors.New("not found")
+	}
+	return "value-of-" + ref.String(), nil
+}
+
+func TestPrefetchSecrets(t *testing.T) {
+	newWorld := func() (*world.World, *slowProvider) {
+		w := world.New(context.Background(), nil)
+		p := &slowProvider{calls: make(map[string]int)}
+		for _, scheme := range []string{"vault", "azure", "aws", "ssm"} {
+			w.RegisterSecretProvider(scheme, func() world.SecretProvider { return p })
+		}
+		return w, p
+	}
+
+	t.Run("concurrent", func(t *testing.T) {
+		w, p := newWorld()
+		var tmpl strings.Builder
+		for i := 0; i < 10; i++ {
+			fmt.Fprintf(&tmpl, "{{ vault \"app%d\" \"password\" }}\n", i)
+		}
+		tmpl.WriteString(`{{ .Azure.Secret "db" }} {{ $.AWS.Secret "prod/db" "user" }} {{ .AWS.Parameter "/app/host" }} {{ secret "vault://app0?version=2#password" }}`)
+		var out bytes.Buffer
+		require.NoError(t, w.Render(&out, strings.NewReader(tmpl.String())))
+		require.Contains(t, out.String(), "value-of-app9#password")
+		require.Contains(t, out.String(), "value-of-prod/db#user")
+		require.Greater(t, p.maxRun, 1)
+		require.Len(t, p.calls, 14)
+		for ref, calls := range p.calls {
+			require.Equal(t, 1, calls, ref)
+		}
+	})
+
+	t.Run("dynamic", func(t *testing.T) {
+		w, p := newWorld()
+		var out bytes.Buffer
+		tmpl := `{{ $path := "app" }}{{ vault $path "password" }} {{ "field" | vault "other" }} {{ if false }}{{ vault "unused" "password" }}{{ end }}`
+		require.NoError(t, w.Render(&out, strings.NewReader(tmpl)))
+		require.Equal(t, "value-of-app#password value-of-other#field ", out.String())
+		require.Equal(t, map[string]int{"app#password": 1, "other#field": 1, "unused#password": 1}, p.calls)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		w, p := newWorld()
+		var out bytes.Buffer
+		err := w.Render(&out, strings.NewReader(`{{ vault "missing" "password" }}`))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "not found")
+		// The render reports the failed prefetch without retrying it.
+		require.Equal(t, 1, p.calls["missing#password"])
+		require.Len(t, w.AuditReport().Secrets, 1)
+
+		w, p = newWorld()
+		require.NoError(t, w.Render(&out, strings.NewReader(`{{ if false }}{{ vault "missing" "password" }}{{ end }}`)))
+		require.Equal(t, 1, p.calls["missing#password"])
+		require.Empty(t, w.AuditReport().Secrets)
+	})
+
+	t.Run("rebound", func(t *testing.T) {
+		w, p := newWorld()
+		var out bytes.Buffer
+		tmpl := `{{ with $.Data }}{{ .Vault.Secret "app" "password" }}{{ else }}{{ $.Vault.Secret "app" "user" }}{{ end }}`
+		require.NoError(t, w.Render(&out, strings.NewReader(tmpl)))
+		require.Equal(t, "value-of-app#user", out.String())
+		require.Equal(t, map[string]int{"app#user": 1}, p.calls)
+
+		// . and $ of defined templates depend on how they are invoked.
+		w, p = newWorld()
+		tmpl = `{{ define "x" }}{{ .Vault.Secret "app" "password" }}{{ $.Vault.Secret "app" "token" }}{{ end }}{{ template "x" .Data }}`
+		w.Render(&out, strings.NewReader(tmpl))
+		require.Empty(t, p.calls)
+	})
+}
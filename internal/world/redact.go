@@ -0,0 +1,124 @@
+package world
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RedactedValue is used in place of secret values in redacted output.
+const RedactedValue = "***"
+
+// MinRedactedLength is the length secret values need to have in order to
+// be redacted. Shorter values (like ports or flags stored next to real
+// secrets) would otherwise mangle unrelated parts of the output.
+const MinRedactedLength = 6
+
+// redactable reports whether value is worth redacting. Values consisting of
+// digits only and booleans are most likely no secrets but would match all
+// over the output.
+func redactable(value string) bool {
+	value = strings.TrimSpace(value)
+	if len(value) < MinRedactedLength {
+		return false
+	}
+	if _, err := strconv.ParseBool(value); err == nil {
+		return false
+	}
+	return strings.Trim(value, "0123456789") != ""
+}
+
+// trackSecretValue remembers value so that it is removed by Redact.
+func (w *World) trackSecretValue(value string) {
+	w.redactMu.Lock()
+	defer w.redactMu.Unlock()
+	if !redactable(value) {
+		if strings.TrimSpace(value) != "" {
+			w.unredacted[value] = struct{}{}
+		}
+		return
+	}
+	w.secretValues[value] = struct{}{}
+	// Values also show up JSON-encoded (e.g. in log lines or when a
+	// template uses toJson).
+	if raw, err := json.Marshal(value); err == nil {
+		if escaped := string(raw[1 : len(raw)-1]); escaped != value {
+			w.secretValues[escaped] = struct{}{}
+		}
+	}
+}
+
+// Redact replaces every secret value handed out to a template so far with
+// RedactedValue.
+func (w *World) Redact(s string) string {
+	w.redactMu.Lock()
+	values := make([]string, 0, len(w.secretValues))
+	for value := range w.secretValues {
+		values = append(values, value)
+	}
+	w.redactMu.Unlock()
+	if len(values) == 0 {
+		return s
+	}
+	// Replace longer values first so that secrets containing other secrets
+	// are redacted completely.
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+	for _, value := range values {
+		s = strings.ReplaceAll(s, value, RedactedValue)
+	}
+	return s
+}
+
+// UnredactedSecrets returns the number of distinct secret values handed out
+// to a template so far which Redact leaves in place as they are too short,
+// numbers or booleans.
+func (w *World) UnredactedSecrets() int {
+	w.redactMu.Lock()
+	defer w.redactMu.Unlock()
+	return len(w.unredacted)
+}
+
+// redactWriter redacts everything written to it before passing it on. It
+// is used as output of the world's logger.
+type redactWriter struct {
+	world *World
+	out   io.Writer
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.out, r.world.Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redactedError hides secret values in the message of the wrapped error.
+type redactedError struct {
+	msg   string
+	cause error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.cause
+}
+
+// redactError returns err with all secret values removed from its message.
+func (w *World) redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	redacted := w.Redact(msg)
+	if redacted == msg {
+		return err
+	}
+	return &redactedError{msg: redacted, cause: err}
+}
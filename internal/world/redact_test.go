@@ -0,0 +1,115 @@
+package world_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/zerok/tpl/internal/world"
+)
+
+func TestRedact(t *testing.T) {
+	w := world.New(context.Background(), nil)
+	p := &fakeProvider{secrets: map[string]string{
+		"app#password": "s3cret",
+		"app#token":    "s3cret-token",
+	}}
+	w.RegisterSecretProvider("fake", func() world.SecretProvider { return p })
+	require.Equal(t, "password: s3cret", w.Redact("password: s3cret"))
+
+	var out bytes.Buffer
+	err := w.Render(&out, bytes.NewBufferString(`{{ secret "fake://app#password" }} {{ secret "fake://app#token" }}`))
+	require.NoError(t, err)
+	require.Equal(t, "s3cret s3cret-token", out.String())
+	require.Equal(t, "*** ***", w.Redact(out.String()))
+}
+
+func TestRedactShortValues(t *testing.T) {
+	w := world.New(context.Background(), nil)
+	p := &fakeProvider{secrets: map[string]string{
+		"app#port":     "5432",
+		"app#user":     "app",
+		"app#enabled":  "true",
+		"app#pin":      "12345678",
+		"app#password": "s3cret",
+	}}
+	w.RegisterSecretProvider("fake", func() world.SecretProvider { return p })
+
+	var out bytes.Buffer
+	err := w.Render(&out, bytes.NewBufferString(`{{ secret "fake://app#user" }}:{{ secret "fake://app#password" }}@db:{{ secret "fake://app#port" }} {{ secret "fake://app#enabled" }} {{ secret "fake://app#pin" }}`))
+	require.NoError(t, err)
+	require.Equal(t, "app:s3cret@db:5432 true 12345678", out.String())
+	require.Equal(t, "app:***@db:5432 true 12345678", w.Redact(out.String()))
+	require.Equal(t, 4, w.UnredactedSecrets())
+	require.Equal(t, "application started", w.Redact("application started"))
+}
+
+func TestRedactLogsAndErrors(t *testing.T) {
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs).Level(zerolog.DebugLevel)
+	w := world.New(logger.WithContext(context.Background()), &world.Options{LogOutput: &logs})
+	p := &fakeProvider{secrets: map[string]string{
+		"app#password": "s3cret",
+		"app#json":     `{"pass": "s3"cret"}`,
+	}}
+	w.RegisterSecretProvider("fake", func() world.SecretProvider { return p })
+
+	var out bytes.Buffer
+	require.NoError(t, w.Render(&out, bytes.NewBufferString(`{{ secret "fake://app#password" }} {{ secret "fake://app#json" | toJson }}`)))
+	require.Equal(t, `*** "***"`, w.Redact(out.String()))
+
+	err := w.Render(&out, bytes.NewBufferString(`{{ fail (secret "fake://app#password") }}`))
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "s3cret")
+	require.Contains(t, err.Error(), world.RedactedValue)
+
+	// Failed prefetches are not logged.
+	require.NoError(t, w.Render(&out, bytes.NewBufferString(`{{ if false }}{{ secret "fake://s3cret" }}{{ end }}`)))
+	require.NotContains(t, logs.String(), "s3cret")
+}
+
+// TestRedactLogsWhileRetrieving makes sure that log lines written while
+// secrets are being retrieved (e.g. when a provider is set up) don't block.
+func TestRedactLogsWhileRetrieving(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"errors": ["permission denied"]}`)
+	}))
+	t.Cleanup(srv.Close)
+	// The secret cache cannot be created below a regular file.
+	blocker := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, ioutil.WriteFile(blocker, nil, 0600))
+
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs).Level(zerolog.DebugLevel)
+	w := world.New(logger.WithContext(context.Background()), &world.Options{
+		Env: map[string]string{
+			"VAULT_ADDR":            srv.URL,
+			world.SecretCacheKeyEnv: "test-key",
+		},
+		EnvOnly:     true,
+		SecretCache: filepath.Join(blocker, "cache"),
+		LogOutput:   &logs,
+	})
+	done := make(chan error, 1)
+	go func() {
+		var out bytes.Buffer
+		done <- w.Render(&out, bytes.NewBufferString(`{{ vault "kv/app" "password" }}`))
+	}()
+	select {
+	case err := <-done:
+		require.Error(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("render did not finish")
+	}
+	require.Contains(t, logs.String(), "VAULT_TOKEN not set")
+	require.Contains(t, logs.String(), "Secret cache disabled")
+}
@@ -0,0 +1,262 @@
+package world
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// SecretCacheKeyEnv can be used to provide the key the on-disk secret cache
+// is encrypted with. If it is not set, a random key is generated and stored
+// in the user's configuration directory. That key file only keeps the cache
+// from being readable on its own (e.g. in backups of the cache directory):
+// anybody who can read the configuration directory can decrypt the cache.
+const SecretCacheKeyEnv = "TPL_SECRET_CACHE_KEY"
+
+// DefaultSecretCacheTTL is used if Options.SecretCache is set without a TTL.
+const DefaultSecretCacheTTL = 10 * time.Minute
+
+// cacheScoper is implemented by secret providers whose values may be cached
+// on disk. The scope identifies the backend instance (e.g. the Vault
+// address) as well as the identity used to authenticate so that entries of
+// different instances or credentials never get mixed up. Providers without
+// a scope are never cached on disk.
+type cacheScoper interface {
+	cacheScope() string
+}
+
+// cacheIdentity returns a hash of the given credentials which can be made
+// part of a cache scope without keeping the credentials themselves around.
+func cacheIdentity(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// secretDiskCache stores secret values encrypted with AES-GCM. File names
+// are derived from the cache key using HMAC so that they don't reveal which
+// secrets have been cached.
+type secretDiskCache struct {
+	dir  string
+	ttl  time.Duration
+	aead cipher.AEAD
+	key  []byte
+}
+
+type secretCacheEntry struct {
+	Expires time.Time `json:"expires"`
+	Value   string    `json:"value"`
+}
+
+// secretCacheKey returns the key from TPL_SECRET_CACHE_KEY or from the key
+// file, which is created if it doesn't exist yet.
+func secretCacheKey(getenv func(string) string) ([]byte, error) {
+	if raw := getenv(SecretCacheKeyEnv); raw != "" {
+		key := sha256.Sum256([]byte(raw))
+		return key[:], nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to determine configuration directory (set %s instead)", SecretCacheKeyEnv)
+	}
+	path := filepath.Join(configDir, "tpl", "secret-cache.key")
+	if raw, err := ioutil.ReadFile(path); err == nil {
+		key, err := hex.DecodeString(string(raw))
+		if err != nil || len(key) != 32 {
+			return nil, errors.Errorf("invalid secret cache key in %s", path)
+		}
+		return key, nil
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "failed to read secret cache key")
+	}
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, errors.Wrap(err, "failed to generate secret cache key")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create directory for secret cache key")
+	}
+	if err := ioutil.WriteFile(path, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		return nil, errors.Wrap(err, "failed to store secret cache key")
+	}
+	return key, nil
+}
+
+func newSecretDiskCache(dir string, ttl time.Duration, getenv func(string) string) (*secretDiskCache, error) {
+	key, err := secretCacheKey(getenv)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "failed to create secret cache directory %s", dir)
+	}
+	if ttl <= 0 {
+		ttl = DefaultSecretCacheTTL
+	}
+	return &secretDiskCache{dir: dir, ttl: ttl, aead: aead, key: key}, nil
+}
+
+func (c *secretDiskCache) path(name string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(name))
+	return filepath.Join(c.dir, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// get returns the cached value for name. Expired entries are removed.
+func (c *secretDiskCache) get(name string) (string, bool, error) {
+	path := c.path(name)
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	size := c.aead.NonceSize()
+	if len(raw) < size {
+		os.Remove(path)
+		return "", false, errors.Errorf("cache entry %s is corrupt", path)
+	}
+	// The name is used as additional data so that entries cannot be moved
+	// to another name.
+	plain, err := c.aead.Open(nil, raw[:size], raw[size:], []byte(name))
+	if err != nil {
+		os.Remove(path)
+		return "", false, errors.Wrapf(err, "failed to decrypt cache entry %s", path)
+	}
+	var entry secretCacheEntry
+	if err := json.Unmarshal(plain, &entry); err != nil {
+		os.Remove(path)
+		return "", false, errors.Wrapf(err, "failed to decode cache entry %s", path)
+	}
+	if time.Now().After(entry.Expires) {
+		os.Remove(path)
+		return "", false, nil
+	}
+	return entry.Value, true, nil
+}
+
+// set stores value under name. The entry expires after the TTL of the cache
+// or after ttl if it is shorter.
+func (c *secretDiskCache) set(name, value string, ttl time.Duration) error {
+	if ttl <= 0 || ttl > c.ttl {
+		ttl = c.ttl
+	}
+	plain, err := json.Marshal(secretCacheEntry{
+		Expires: time.Now().Add(ttl),
+		Value:   value,
+	})
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	sealed := c.aead.Seal(nonce, nonce, plain, []byte(name))
+	tmp, err := ioutil.TempFile(c.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(sealed); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(name))
+}
+
+// diskCache lazily sets up the on-disk cache. It returns nil if the cache is
+// disabled or cannot be used.
+func (w *World) diskCache() *secretDiskCache {
+	w.secretMu.Lock()
+	if w.secretCacheDir == "" || w.secretCacheErr != nil {
+		w.secretMu.Unlock()
+		return nil
+	}
+	if w.secretDiskCache == nil {
+		w.secretDiskCache, w.secretCacheErr = newSecretDiskCache(w.secretCacheDir, w.secretCacheTTL, w.getenv)
+	}
+	cache, err := w.secretDiskCache, w.secretCacheErr
+	w.secretMu.Unlock()
+	if err != nil {
+		// Only reached once as the error is remembered.
+		zerolog.Ctx(w.ctx).Warn().Err(err).Msg("Secret cache disabled")
+		return nil
+	}
+	return cache
+}
+
+// diskCacheName returns the name the value of key is stored under on disk.
+// It returns false if the provider cannot be cached on disk (e.g. because
+// the identity used to authenticate is unknown).
+func diskCacheName(p SecretProvider, key string) (string, bool) {
+	scoper, ok := p.(cacheScoper)
+	if !ok {
+		return "", false
+	}
+	scope := scoper.cacheScope()
+	if scope == "" {
+		return "", false
+	}
+	return scope + "|" + key, true
+}
+
+// cachedSecret returns the value stored on disk for the given lookup key.
+func (w *World) cachedSecret(p SecretProvider, key string) (string, bool) {
+	name, ok := diskCacheName(p, key)
+	if !ok {
+		return "", false
+	}
+	cache := w.diskCache()
+	if cache == nil {
+		return "", false
+	}
+	value, ok, err := cache.get(name)
+	if err != nil {
+		zerolog.Ctx(w.ctx).Warn().Err(err).Msg("Failed to read from secret cache")
+	}
+	return value, ok
+}
+
+// storeSecret writes a freshly retrieved value to the on-disk cache. lease
+// limits how long it is kept if it is not 0.
+func (w *World) storeSecret(p SecretProvider, key, value string, lease time.Duration) {
+	name, ok := diskCacheName(p, key)
+	if !ok {
+		return
+	}
+	cache := w.diskCache()
+	if cache == nil {
+		return
+	}
+	if err := cache.set(name, value, lease); err != nil {
+		zerolog.Ctx(w.ctx).Warn().Err(err).Msg("Failed to write to secret cache")
+	}
+}
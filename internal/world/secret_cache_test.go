@@ -0,0 +1,119 @@
+package world_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zerok/tpl/internal/world"
+)
+
+// newCountingVault works like newFakeVault but counts how often secrets
+// below kv/ were read.
+func newCountingVault(t *testing.T, reads *int) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/kv/") {
+			*reads++
+		}
+		fakeVault.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSecretCache(t *testing.T) {
+	tmpl := `{{ vault "kv/app" "username" }}:{{ vault "kv/app" "password" }}:{{ secret "vault://kv/app#password" }}`
+	renderTemplate := func(t *testing.T, opts *world.Options, tmpl string) string {
+		w := world.New(context.Background(), opts)
+		var out bytes.Buffer
+		require.NoError(t, w.Render(&out, bytes.NewBufferString(tmpl)))
+		return out.String()
+	}
+	render := func(t *testing.T, opts *world.Options) {
+		require.Equal(t, "admin:v1-pw:v1-pw", renderTemplate(t, opts, tmpl))
+	}
+	newOptions := func(addr, dir string, ttl time.Duration) *world.Options {
+		return &world.Options{
+			EnvOnly: true,
+			Env: map[string]string{
+				"VAULT_ADDR":            addr,
+				"VAULT_TOKEN":           "test-token",
+				world.SecretCacheKeyEnv: "test-key",
+			},
+			SecretCache:    dir,
+			SecretCacheTTL: ttl,
+		}
+	}
+
+	t.Run("memory", func(t *testing.T) {
+		var reads int
+		srv := newCountingVault(t, &reads)
+		render(t, newOptions(srv.URL, "", 0))
+		require.Equal(t, 1, reads)
+	})
+
+	t.Run("disk", func(t *testing.T) {
+		var reads int
+		srv := newCountingVault(t, &reads)
+		dir := t.TempDir()
+		render(t, newOptions(srv.URL, dir, time.Minute))
+		require.Equal(t, 1, reads)
+		render(t, newOptions(srv.URL, dir, time.Minute))
+		require.Equal(t, 1, reads)
+
+		entries, err := filepath.Glob(filepath.Join(dir, "*"))
+		require.NoError(t, err)
+		require.NotEmpty(t, entries)
+		for _, entry := range entries {
+			raw, err := ioutil.ReadFile(entry)
+			require.NoError(t, err)
+			require.False(t, strings.Contains(string(raw), "v1-pw"), "cache entries must be encrypted")
+		}
+
+		// A different key cannot decrypt the existing entries.
+		opts := newOptions(srv.URL, dir, time.Minute)
+		opts.Env[world.SecretCacheKeyEnv] = "other-key"
+		render(t, opts)
+		require.Equal(t, 2, reads)
+
+		// Entries are not shared between different credentials.
+		opts = newOptions(srv.URL, dir, time.Minute)
+		opts.Env["VAULT_TOKEN"] = "other-token"
+		render(t, opts)
+		require.Equal(t, 3, reads)
+		render(t, opts)
+		require.Equal(t, 3, reads)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		var reads int
+		srv := newCountingVault(t, &reads)
+		dir := t.TempDir()
+		render(t, newOptions(srv.URL, dir, time.Nanosecond))
+		render(t, newOptions(srv.URL, dir, time.Nanosecond))
+		require.Equal(t, 2, reads)
+	})
+
+	t.Run("lease", func(t *testing.T) {
+		var reads int
+		srv := newCountingVault(t, &reads)
+		dir := t.TempDir()
+		short := `{{ vault "kv/short-lease" "password" }}`
+		require.Equal(t, "short-pw", renderTemplate(t, newOptions(srv.URL, dir, time.Hour), short))
+		require.Equal(t, "short-pw", renderTemplate(t, newOptions(srv.URL, dir, time.Hour), short))
+		require.Equal(t, 1, reads)
+
+		// The entry expires with the lease even though the cache TTL is
+		// longer.
+		time.Sleep(1100 * time.Millisecond)
+		require.Equal(t, "short-pw", renderTemplate(t, newOptions(srv.URL, dir, time.Hour), short))
+		require.Equal(t, 2, reads)
+	})
+}
@@ -0,0 +1,307 @@
+package world
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmespath/go-jmespath"
+	"github.com/pkg/errors"
+)
+
+// SecretRef identifies a single secret value inside a SecretProvider.
+type SecretRef struct {
+	// Path of the secret within the provider.
+	Path string
+
+	// Field selects a single value of the secret if the provider stores
+	// multiple values per path (optional).
+	Field string
+
+	// Version pins a specific version of the secret (optional).
+	Version string
+}
+
+// String returns the reference in the form accepted by the secret template
+// function (without the scheme).
+func (r SecretRef) String() string {
+	s := r.Path
+	if r.Version != "" {
+		s += "?version=" + r.Version
+	}
+	if r.Field != "" {
+		s += "#" + r.Field
+	}
+	return s
+}
+
+// SecretProvider is implemented by every backend secrets can be retrieved
+// from. The path of the given reference has already been prefixed and mapped.
+type SecretProvider interface {
+	FetchSecret(ref SecretRef) (string, error)
+}
+
+// PathMapping contains the prefix and key mapping which are applied to every
+// path before it is handed over to a SecretProvider. Providers embedding it
+// can be configured using --*-prefix and --*-mapping.
+type PathMapping struct {
+	Prefix     string
+	KeyMapping map[string]string
+}
+
+// MapPath applies the prefix and afterwards the key mapping to the given
+// path.
+func (m *PathMapping) MapPath(path string) string {
+	prefixPath := fmt.Sprintf("%s%s", m.Prefix, path)
+	if mapped, ok := m.KeyMapping[prefixPath]; ok {
+		return mapped
+	}
+	return prefixPath
+}
+
+// Mapping exposes the mapping to the secret lookup code.
+func (m *PathMapping) Mapping() *PathMapping {
+	return m
+}
+
+type pathMapper interface {
+	Mapping() *PathMapping
+}
+
+// RegisterSecretProvider makes a provider available under the given scheme
+// (e.g. "vault" for vault://secret/path#field). The factory is only called
+// once the first secret is requested from that provider. Registering a
+// scheme a second time replaces the previous provider.
+func (w *World) RegisterSecretProvider(scheme string, factory func() SecretProvider) {
+	w.providerMu.Lock()
+	defer w.providerMu.Unlock()
+	w.secretFactories[scheme] = factory
+	delete(w.secretProviders, scheme)
+}
+
+// SecretProviders returns the sorted list of registered schemes.
+func (w *World) SecretProviders() []string {
+	w.providerMu.Lock()
+	defer w.providerMu.Unlock()
+	result := make([]string, 0, len(w.secretFactories))
+	for scheme := range w.secretFactories {
+		result = append(result, scheme)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (w *World) secretProvider(scheme string) (SecretProvider, error) {
+	w.providerMu.Lock()
+	defer w.providerMu.Unlock()
+	if p, ok := w.secretProviders[scheme]; ok {
+		return p, nil
+	}
+	factory, ok := w.secretFactories[scheme]
+	if !ok {
+		return nil, errors.Errorf("no secret provider registered for %s://", scheme)
+	}
+	p := factory()
+	w.secretProviders[scheme] = p
+	return p, nil
+}
+
+// Secret resolves a secret reference of the form scheme://path#field. A
+// version can be requested using scheme://path?version=3#field.
+func (w *World) Secret(uri string) (string, error) {
+	scheme, ref, err := ParseSecretURI(uri)
+	if err != nil {
+		return "", err
+	}
+	return w.lookupSecret(scheme, ref)
+}
+
+// ParseSecretURI splits a secret URI into the provider scheme and the
+// reference inside that provider.
+func ParseSecretURI(uri string) (string, SecretRef, error) {
+	var ref SecretRef
+	elems := strings.SplitN(uri, "://", 2)
+	if len(elems) != 2 || elems[0] == "" {
+		return "", ref, errors.Errorf("invalid secret reference `%s` (expected scheme://path)", uri)
+	}
+	rest := elems[1]
+	if idx := strings.Index(rest, "#"); idx != -1 {
+		ref.Field = rest[idx+1:]
+		rest = rest[:idx]
+	}
+	if idx := strings.Index(rest, "?"); idx != -1 {
+		query := rest[idx+1:]
+		rest = rest[:idx]
+		for _, param := range strings.Split(query, "&") {
+			kv := strings.SplitN(param, "=", 2)
+			if len(kv) != 2 || kv[0] != "version" {
+				return "", ref, errors.Errorf("unsupported parameter `%s` in secret reference `%s`", param, uri)
+			}
+			ref.Version = kv[1]
+		}
+	}
+	if rest == "" {
+		return "", ref, errors.Errorf("secret reference `%s` has no path", uri)
+	}
+	ref.Path = rest
+	return elems[0], ref, nil
+}
+
+// lookupSecret is the code path shared by all secret providers. Every lookup
+// is recorded in the audit report.
+func (w *World) lookupSecret(scheme string, ref SecretRef) (string, error) {
+	value, resolved, err := w.fetchSecret(scheme, ref)
+	w.recordSecretAccess(scheme, ref, resolved, err)
+	return value, err
+}
+
+// fieldExtractor is implemented by providers which store multiple fields
+// per secret. The world then requests the secret without field and extracts
+// the fields itself so that every secret is only retrieved once no matter
+// how many of its fields are used.
+type fieldExtractor interface {
+	ExtractField(document string, ref SecretRef) (string, error)
+}
+
+// leasedProvider is implemented by providers whose secrets can expire (e.g.
+// Vault leases). Next to the value, the lifetime of the secret is returned
+// (0 if it doesn't expire) which limits how long it is cached on disk.
+type leasedProvider interface {
+	fetchLeasedSecret(ref SecretRef) (string, time.Duration, error)
+}
+
+// fetchSecret applies the provider's path mapping, serves repeated lookups
+// from memory (and the on-disk cache if enabled) and reports errors in a
+// uniform way. Failed lookups are not retried until the next render. Next to
+// the value, the path after applying the mapping is returned.
+func (w *World) fetchSecret(scheme string, ref SecretRef) (string, string, error) {
+	p, err := w.secretProvider(scheme)
+	if err != nil {
+		return "", ref.Path, err
+	}
+	mapped := ref
+	if m, ok := p.(pathMapper); ok {
+		mapped.Path = m.Mapping().MapPath(ref.Path)
+	}
+	// fetch retrieves ref from the on-disk cache or the provider.
+	fetch := func(key string, ref SecretRef) func() (string, error) {
+		return func() (string, error) {
+			if value, ok := w.cachedSecret(p, key); ok {
+				return value, nil
+			}
+			var value string
+			var lease time.Duration
+			var err error
+			if l, ok := p.(leasedProvider); ok {
+				value, lease, err = l.fetchLeasedSecret(ref)
+			} else {
+				value, err = p.FetchSecret(ref)
+			}
+			if err != nil {
+				return "", err
+			}
+			w.storeSecret(p, key, value, lease)
+			return value, nil
+		}
+	}
+	key := scheme + "://" + mapped.String()
+	var value string
+	if e, ok := p.(fieldExtractor); ok && mapped.Field != "" {
+		document := SecretRef{Path: mapped.Path, Version: mapped.Version}
+		documentKey := scheme + "://" + document.String()
+		value, err = w.retrieveSecret(key, func() (string, error) {
+			raw, err := w.retrieveSecret(documentKey, fetch(documentKey, document))
+			if err != nil {
+				return "", err
+			}
+			return e.ExtractField(raw, mapped)
+		})
+	} else {
+		value, err = w.retrieveSecret(key, fetch(key, mapped))
+	}
+	if err != nil {
+		return "", mapped.Path, errors.Wrapf(err, "%s: failed to retrieve secret %s", scheme, ref.String())
+	}
+	return value, mapped.Path, nil
+}
+
+// retrieveSecret returns the value stored in memory for key or calls fetch
+// to retrieve it. Errors are remembered as well.
+func (w *World) retrieveSecret(key string, fetch func() (string, error)) (string, error) {
+	w.secretMu.Lock()
+	value, ok := w.secretCache[key]
+	err := w.secretErrors[key]
+	w.secretMu.Unlock()
+	if ok || err != nil {
+		return value, err
+	}
+	value, err = fetch()
+	if err == nil {
+		w.trackSecretValue(value)
+	}
+	w.secretMu.Lock()
+	defer w.secretMu.Unlock()
+	if err != nil {
+		w.secretErrors[key] = err
+		return "", err
+	}
+	w.secretCache[key] = value
+	return value, nil
+}
+
+// ResetSecrets forgets all the secrets retrieved so far as well as failed
+// lookups and their lifetime so that they are retrieved again by the next
+// render. The secret providers and their authentication are kept.
+func (w *World) ResetSecrets() {
+	w.secretMu.Lock()
+	defer w.secretMu.Unlock()
+	w.secretCache = make(map[string]string)
+	w.secretErrors = make(map[string]error)
+	w.secretTTL = 0
+}
+
+// recordSecretTTL is called by providers whose secrets expire (e.g. Vault
+// leases).
+func (w *World) recordSecretTTL(ttl time.Duration) {
+	w.secretMu.Lock()
+	defer w.secretMu.Unlock()
+	if w.secretTTL == 0 || ttl < w.secretTTL {
+		w.secretTTL = ttl
+	}
+}
+
+// SecretTTL returns the shortest lifetime of all the secrets retrieved so
+// far or 0 if none of them expires.
+func (w *World) SecretTTL() time.Duration {
+	w.secretMu.Lock()
+	defer w.secretMu.Unlock()
+	return w.secretTTL
+}
+
+// jsonField treats the given secret value as JSON document and returns the
+// value the JMESPath expression field points to.
+func jsonField(value, field string) (string, error) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(value), &data); err != nil {
+		return "", errors.Wrap(err, "secret is not a JSON document")
+	}
+	result, err := jmespath.Search(field, data)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to evaluate `%s`", field)
+	}
+	if result == nil {
+		return "", errors.Errorf("secret has no field named '%s'", field)
+	}
+	switch v := result.(type) {
+	case string:
+		return v, nil
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(raw), nil
+	}
+}
@@ -0,0 +1,151 @@
+package world_test
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/zerok/tpl/internal/world"
+)
+
+// fakeProvider serves secrets from a map keyed by SecretRef.String() and
+// counts how often it was asked.
+type fakeProvider struct {
+	world.PathMapping
+	secrets map[string]string
+	calls   int
+	mu      sync.Mutex
+}
+
+func (p *fakeProvider) FetchSecret(ref world.SecretRef) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	value, ok := p.secrets[ref.String()]
+	if !ok {
+		return "", errors.Errorf("%s not found", ref.String())
+	}
+	return value, nil
+}
+
+func TestParseSecretURI(t *testing.T) {
+	tests := []struct {
+		uri     string
+		scheme  string
+		ref     world.SecretRef
+		errored bool
+	}{
+		{uri: "vault://secret/app#password", scheme: "vault", ref: world.SecretRef{Path: "secret/app", Field: "password"}},
+		{uri: "vault://secret/app?version=3#password", scheme: "vault", ref: world.SecretRef{Path: "secret/app", Field: "password", Version: "3"}},
+		{uri: "azure://db-pass", scheme: "azure", ref: world.SecretRef{Path: "db-pass"}},
+		{uri: "db-pass", errored: true},
+		{uri: "azure://", errored: true},
+		{uri: "vault://secret/app?ttl=3", errored: true},
+	}
+	for _, test := range tests {
+		t.Run(test.uri, func(t *testing.T) {
+			scheme, ref, err := world.ParseSecretURI(test.uri)
+			if test.errored {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.scheme, scheme)
+			require.Equal(t, test.ref, ref)
+		})
+	}
+}
+
+func TestSecretProviderRegistry(t *testing.T) {
+	render := func(w *world.World, tmpl string) (string, error) {
+		var out bytes.Buffer
+		err := w.Render(&out, bytes.NewBufferString(tmpl))
+		return out.String(), err
+	}
+
+	t.Run("custom-provider", func(t *testing.T) {
+		w := world.New(context.Background(), nil)
+		p := &fakeProvider{secrets: map[string]string{"app#password": "s3cret"}}
+		w.RegisterSecretProvider("fake", func() world.SecretProvider { return p })
+		require.Contains(t, w.SecretProviders(), "fake")
+		out, err := render(w, `{{ secret "fake://app#password" }}`)
+		require.NoError(t, err)
+		require.Equal(t, "s3cret", out)
+	})
+
+	t.Run("unknown-provider", func(t *testing.T) {
+		w := world.New(context.Background(), nil)
+		_, err := render(w, `{{ secret "nope://app#password" }}`)
+		require.Error(t, err)
+	})
+
+	t.Run("cached", func(t *testing.T) {
+		w := world.New(context.Background(), nil)
+		p := &fakeProvider{secrets: map[string]string{"app#password": "s3cret"}}
+		w.RegisterSecretProvider("fake", func() world.SecretProvider { return p })
+		out, err := render(w, `{{ secret "fake://app#password" }}{{ secret "fake://app#password" }}`)
+		require.NoError(t, err)
+		require.Equal(t, "s3crets3cret", out)
+		require.Equal(t, 1, p.calls)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		w := world.New(context.Background(), nil)
+		created := 0
+		p := &fakeProvider{secrets: map[string]string{"app#password": "s3cret"}}
+		w.RegisterSecretProvider("fake", func() world.SecretProvider {
+			created++
+			return p
+		})
+		_, err := render(w, `{{ secret "fake://app#password" }}`)
+		require.NoError(t, err)
+		p.secrets["app#password"] = "rotated"
+		out, err := render(w, `{{ secret "fake://app#password" }}`)
+		require.NoError(t, err)
+		require.Equal(t, "s3cret", out)
+
+		w.ResetSecrets()
+		out, err = render(w, `{{ secret "fake://app#password" }}`)
+		require.NoError(t, err)
+		require.Equal(t, "rotated", out)
+		require.Equal(t, 2, p.calls)
+		require.Equal(t, 1, created, "the provider is kept")
+	})
+
+	t.Run("prefix-and-mapping", func(t *testing.T) {
+		w := world.New(context.Background(), nil)
+		p := &fakeProvider{secrets: map[string]string{
+			"prod/app#password": "prefixed",
+			"new/db#password":   "mapped",
+		}}
+		p.Prefix = "prod/"
+		p.KeyMapping = map[string]string{"prod/db": "new/db"}
+		w.RegisterSecretProvider("fake", func() world.SecretProvider { return p })
+		out, err := render(w, `{{ secret "fake://app#password" }} {{ secret "fake://db#password" }}`)
+		require.NoError(t, err)
+		require.Equal(t, "prefixed mapped", out)
+	})
+
+	t.Run("builtin-functions-use-registry", func(t *testing.T) {
+		w := world.New(context.Background(), nil)
+		vault := &fakeProvider{secrets: map[string]string{"secret/app?version=2#password": "from-vault"}}
+		azure := &fakeProvider{secrets: map[string]string{"db-pass": "from-azure"}}
+		w.RegisterSecretProvider("vault", func() world.SecretProvider { return vault })
+		w.RegisterSecretProvider("azure", func() world.SecretProvider { return azure })
+		out, err := render(w, `{{ vault "secret/app" "password" 2 }} {{ .Azure.Secret "db-pass" }}`)
+		require.NoError(t, err)
+		require.Equal(t, "from-vault from-azure", out)
+	})
+
+	t.Run("errors-name-provider", func(t *testing.T) {
+		w := world.New(context.Background(), nil)
+		p := &fakeProvider{}
+		w.RegisterSecretProvider("fake", func() world.SecretProvider { return p })
+		_, err := render(w, `{{ secret "fake://app#password" }}`)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "fake: failed to retrieve secret app#password")
+	})
+}
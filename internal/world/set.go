@@ -0,0 +1,188 @@
+package world
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxSetIndex is the largest list index accepted in paths. Lists are
+// padded with null values up to the index, so it has to be limited.
+const maxSetIndex = 9999
+
+// pathElem is a single element of a path like `servers[0].name`: either a
+// map key or a list index.
+type pathElem struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// parseSetPath splits a dotted path with optional list indices into its
+// elements. Dots that are part of a key can be escaped using `\.`.
+func parseSetPath(path string) ([]pathElem, error) {
+	var result []pathElem
+	var key strings.Builder
+	flush := func() {
+		if key.Len() > 0 {
+			result = append(result, pathElem{key: key.String()})
+			key.Reset()
+		}
+	}
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '\\' && i+1 < len(path):
+			i++
+			key.WriteByte(path[i])
+		case c == '.':
+			if key.Len() == 0 && (len(result) == 0 || !result[len(result)-1].isIndex) {
+				return nil, errors.Errorf("empty key in path `%s`", path)
+			}
+			flush()
+		case c == '[':
+			flush()
+			end := strings.IndexByte(path[i:], ']')
+			if end == -1 {
+				return nil, errors.Errorf("unterminated index in path `%s`", path)
+			}
+			idx, err := strconv.Atoi(path[i+1 : i+end])
+			if err != nil || idx < 0 {
+				return nil, errors.Errorf("invalid index `%s` in path `%s`", path[i+1:i+end], path)
+			}
+			if idx > maxSetIndex {
+				return nil, errors.Errorf("index `%d` in path `%s` is larger than %d", idx, path, maxSetIndex)
+			}
+			if len(result) == 0 {
+				return nil, errors.Errorf("path `%s` has to start with a key", path)
+			}
+			result = append(result, pathElem{index: idx, isIndex: true})
+			i += end
+		default:
+			key.WriteByte(c)
+		}
+	}
+	flush()
+	if len(result) == 0 {
+		return nil, errors.Errorf("empty path")
+	}
+	return result, nil
+}
+
+// Set assigns the value to the location described by path (e.g.
+// `db.host` or `servers[0].name`). Missing maps and lists are created and
+// lists are extended as needed.
+func (d Data) Set(path string, value interface{}) error {
+	elems, err := parseSetPath(path)
+	if err != nil {
+		return err
+	}
+	if _, err := setValue(map[string]interface{}(d), elems, value); err != nil {
+		return errors.Wrapf(err, "failed to set `%s`", path)
+	}
+	return nil
+}
+
+func setValue(current interface{}, elems []pathElem, value interface{}) (interface{}, error) {
+	if len(elems) == 0 {
+		return value, nil
+	}
+	elem := elems[0]
+	if elem.isIndex {
+		list, ok := current.([]interface{})
+		if current != nil && !ok {
+			return nil, errors.Errorf("cannot index into non-list value")
+		}
+		for len(list) <= elem.index {
+			list = append(list, nil)
+		}
+		updated, err := setValue(list[elem.index], elems[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		list[elem.index] = updated
+		return list, nil
+	}
+	m, ok := current.(map[string]interface{})
+	if current != nil && !ok {
+		return nil, errors.Errorf("cannot set key `%s` on non-map value", elem.key)
+	}
+	if m == nil {
+		m = make(map[string]interface{})
+	}
+	updated, err := setValue(m[elem.key], elems[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	m[elem.key] = updated
+	return m, nil
+}
+
+// ParseSetValue infers the type of a value passed using --set: booleans,
+// null, integers and floats are converted, everything else (including NaN
+// and infinity, which can't be represented in JSON) is kept as string.
+func ParseSetValue(raw string) interface{} {
+	switch raw {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	if i, err := strconv.Atoi(raw); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(raw, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		return f
+	}
+	return raw
+}
+
+// applySets applies all the --set, --set-string and --set-file assignments
+// of the given options to the data.
+func applySets(d Data, opts *DataOptions, cwd string) error {
+	assignments := []struct {
+		flag   string
+		values []string
+		parse  func(string) (interface{}, error)
+	}{
+		{"set", opts.Set, func(raw string) (interface{}, error) {
+			return ParseSetValue(raw), nil
+		}},
+		{"set-string", opts.SetString, func(raw string) (interface{}, error) {
+			return raw, nil
+		}},
+		{"set-file", opts.SetFile, func(raw string) (interface{}, error) {
+			path := raw
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(cwd, path)
+			}
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			return string(content), nil
+		}},
+	}
+	for _, a := range assignments {
+		for _, assignment := range a.values {
+			elems := strings.SplitN(assignment, "=", 2)
+			if len(elems) != 2 {
+				return errors.Errorf("invalid --%s assignment `%s` (expected path=value)", a.flag, assignment)
+			}
+			value, err := a.parse(elems[1])
+			if err != nil {
+				return errors.Wrapf(err, "failed to process --%s `%s`", a.flag, assignment)
+			}
+			if err := d.Set(elems[0], value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package world_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zerok/tpl/internal/world"
+)
+
+func TestDataSet(t *testing.T) {
+	tests := []struct {
+		path     string
+		value    interface{}
+		expected world.Data
+		errored  bool
+	}{
+		{path: "replicas", value: 3, expected: world.Data{"replicas": 3}},
+		{path: "db.host", value: "10.0.0.1", expected: world.Data{"db": map[string]interface{}{"host": "10.0.0.1"}}},
+		{path: "servers[1].name", value: "b", expected: world.Data{"servers": []interface{}{nil, map[string]interface{}{"name": "b"}}}},
+		{path: `annotations.example\.org/team`, value: "ops", expected: world.Data{"annotations": map[string]interface{}{"example.org/team": "ops"}}},
+		{path: "servers[x]", errored: true},
+		{path: "[0]", errored: true},
+		{path: "a..b", errored: true},
+		{path: "servers[999999999]", errored: true},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			d := world.Data{}
+			err := d.Set(test.path, test.value)
+			if test.errored {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.expected, d)
+		})
+	}
+
+	t.Run("type-mismatch", func(t *testing.T) {
+		d := world.Data{"db": "not-a-map"}
+		require.Error(t, d.Set("db.host", "x"))
+		require.Error(t, d.Set("db[0]", "x"))
+	})
+}
+
+func TestParseSetValue(t *testing.T) {
+	require.Equal(t, true, world.ParseSetValue("true"))
+	require.Equal(t, false, world.ParseSetValue("false"))
+	require.Nil(t, world.ParseSetValue("null"))
+	require.Equal(t, 123, world.ParseSetValue("0123"))
+	require.Equal(t, 1.5, world.ParseSetValue("1.5"))
+	require.Equal(t, "10.0.0.1", world.ParseSetValue("10.0.0.1"))
+	for _, raw := range []string{"nan", "NaN", "inf", "-Inf", "Infinity", "1e999"} {
+		require.Equal(t, raw, world.ParseSetValue(raw))
+	}
+}
+
+func TestDataSetOptions(t *testing.T) {
+	data, err := world.LoadDataWithOptions(context.Background(), []string{"cfg=base.yaml"}, "../../testdata", &world.DataOptions{
+		Set:       []string{"cfg.db.port=6543", "cfg.servers[1]=c", "tag=0123"},
+		SetString: []string{"tag=0123"},
+		SetFile:   []string{"cfg.items=test.yaml"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, world.Data{
+		"cfg": map[string]interface{}{
+			"db":      map[string]interface{}{"host": "localhost", "port": 6543},
+			"servers": []interface{}{"a", "c"},
+			"items":   "- 1\n- 2\n- 3",
+		},
+		"tag": "0123",
+	}, data)
+
+	_, err = world.LoadDataWithOptions(context.Background(), nil, "", &world.DataOptions{Set: []string{"missing-value"}})
+	require.Error(t, err)
+}
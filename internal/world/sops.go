@@ -0,0 +1,277 @@
+package world
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/pkg/errors"
+)
+
+const (
+	// SopsAgeKeyFile points to a file containing age identities (as used by
+	// SOPS itself).
+	SopsAgeKeyFile string = "SOPS_AGE_KEY_FILE"
+
+	// SopsAgeKey can contain age identities directly.
+	SopsAgeKey string = "SOPS_AGE_KEY"
+
+	sopsMetadataKey           string = "sops"
+	sopsDefaultUnencrypted    string = "_unencrypted"
+	sopsDefaultAgeKeyLocation string = "sops/age/keys.txt"
+)
+
+var sopsValuePattern = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)\]$`)
+
+// ageIdentities collects all the age identities configured through the
+// given files, SOPS_AGE_KEY and SOPS_AGE_KEY_FILE. If nothing is configured
+// the default location used by SOPS is tried.
+func ageIdentities(files []string, getenv func(string) string) ([]age.Identity, error) {
+	var result []age.Identity
+	if key := getenv(SopsAgeKey); key != "" {
+		ids, err := age.ParseIdentities(strings.NewReader(key))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse identities in %s", SopsAgeKey)
+		}
+		result = append(result, ids...)
+	}
+	if keyFile := getenv(SopsAgeKeyFile); keyFile != "" {
+		files = append([]string{keyFile}, files...)
+	}
+	if len(files) == 0 && len(result) == 0 {
+		if dir, err := os.UserConfigDir(); err == nil {
+			if _, err := os.Stat(filepath.Join(dir, sopsDefaultAgeKeyLocation)); err == nil {
+				files = append(files, filepath.Join(dir, sopsDefaultAgeKeyLocation))
+			}
+		}
+	}
+	for _, file := range files {
+		fp, err := os.Open(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open age identity file %s", file)
+		}
+		ids, err := age.ParseIdentities(fp)
+		fp.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse age identity file %s", file)
+		}
+		result = append(result, ids...)
+	}
+	if len(result) == 0 {
+		return nil, errors.Errorf("no age identities available (use --age-identity or %s)", SopsAgeKeyFile)
+	}
+	return result, nil
+}
+
+// decryptAge decrypts an age encrypted stream. Both the binary and the
+// armored format are supported.
+func decryptAge(in io.Reader, identities []age.Identity) (io.Reader, error) {
+	rd := bufio.NewReader(in)
+	start, _ := rd.Peek(len(armor.Header))
+	var src io.Reader = rd
+	if string(start) == armor.Header {
+		src = armor.NewReader(rd)
+	}
+	return age.Decrypt(src, identities...)
+}
+
+// isSOPSDocument checks if the given (decoded) document has been encrypted
+// by SOPS: it has to contain the metadata section added by SOPS (with a MAC
+// or version) as well as at least one encrypted value. Plain documents
+// which merely use a sops key of their own are left alone.
+func isSOPSDocument(doc interface{}) bool {
+	rawMeta, ok := mapValue(doc, sopsMetadataKey)
+	if !ok {
+		return false
+	}
+	meta, ok := normalizeValue(rawMeta).(map[string]interface{})
+	if !ok {
+		return false
+	}
+	if _, ok := meta["mac"]; !ok {
+		if _, ok := meta["version"]; !ok {
+			return false
+		}
+	}
+	data, ok := normalizeValue(doc).(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for k, v := range data {
+		if k != sopsMetadataKey && hasSOPSValue(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSOPSValue checks if value is or contains a value encrypted by SOPS.
+func hasSOPSValue(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, item := range v {
+			if hasSOPSValue(item) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if hasSOPSValue(item) {
+				return true
+			}
+		}
+	case string:
+		return strings.HasPrefix(v, "ENC[")
+	}
+	return false
+}
+
+// decryptSOPS decrypts all values of a document encrypted by SOPS using one
+// of the given age identities. The metadata section is removed from the
+// result. Note that the message authentication code of the document is not
+// verified.
+func decryptSOPS(doc interface{}, identities []age.Identity) (interface{}, error) {
+	rawMeta, _ := mapValue(doc, sopsMetadataKey)
+	meta, ok := normalizeValue(rawMeta).(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid sops metadata")
+	}
+	key, err := sopsDataKey(meta, identities)
+	if err != nil {
+		return nil, err
+	}
+	unencryptedSuffix, _ := meta["unencrypted_suffix"].(string)
+	if unencryptedSuffix == "" {
+		unencryptedSuffix = sopsDefaultUnencrypted
+	}
+	var walk func(value interface{}, path []string) (interface{}, error)
+	walk = func(value interface{}, path []string) (interface{}, error) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			result := make(map[string]interface{}, len(v))
+			for k, item := range v {
+				if len(path) == 0 && k == sopsMetadataKey {
+					continue
+				}
+				if strings.HasSuffix(k, unencryptedSuffix) {
+					result[k] = item
+					continue
+				}
+				decrypted, err := walk(item, append(path[:len(path):len(path)], k))
+				if err != nil {
+					return nil, err
+				}
+				result[k] = decrypted
+			}
+			return result, nil
+		case []interface{}:
+			result := make([]interface{}, len(v))
+			for i, item := range v {
+				decrypted, err := walk(item, path)
+				if err != nil {
+					return nil, err
+				}
+				result[i] = decrypted
+			}
+			return result, nil
+		case string:
+			return decryptSOPSValue(v, key, strings.Join(path, ":")+":")
+		default:
+			return v, nil
+		}
+	}
+	return walk(normalizeValue(doc), nil)
+}
+
+// sopsDataKey retrieves the data key from the age recipients listed in the
+// SOPS metadata.
+func sopsDataKey(meta map[string]interface{}, identities []age.Identity) ([]byte, error) {
+	recipients, _ := meta["age"].([]interface{})
+	if len(recipients) == 0 {
+		return nil, errors.New("file is not encrypted for any age recipient")
+	}
+	var lastErr error
+	for _, r := range recipients {
+		recipient, _ := r.(map[string]interface{})
+		enc, _ := recipient["enc"].(string)
+		if enc == "" {
+			continue
+		}
+		plain, err := decryptAge(strings.NewReader(enc), identities)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		key, err := ioutil.ReadAll(plain)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return key, nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no usable age recipient found")
+	}
+	return nil, errors.Wrap(lastErr, "failed to decrypt data key")
+}
+
+// decryptSOPSValue decrypts a single ENC[...] value. Values not following
+// that format are returned as they are.
+func decryptSOPSValue(value string, key []byte, additionalData string) (interface{}, error) {
+	match := sopsValuePattern.FindStringSubmatch(value)
+	if match == nil {
+		return value, nil
+	}
+	var parts [3][]byte
+	for i, raw := range match[1:4] {
+		decoded, err := base64.StdEncoding.DecodeString(raw)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid encrypted value")
+		}
+		parts[i] = decoded
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decrypt value at %s", strings.TrimSuffix(additionalData, ":"))
+	}
+	switch match[4] {
+	case "str", "bytes":
+		return string(plain), nil
+	case "int":
+		return strconv.Atoi(string(plain))
+	case "float":
+		return strconv.ParseFloat(string(plain), 64)
+	case "bool":
+		return strconv.ParseBool(string(plain))
+	case "comment":
+		return nil, nil
+	default:
+		return nil, errors.Errorf("unsupported value type %s", match[4])
+	}
+}
+
+// looksLikeAge checks if the given content starts like an age encrypted
+// file.
+func looksLikeAge(content []byte) bool {
+	return bytes.HasPrefix(content, []byte(armor.Header)) || bytes.HasPrefix(content, []byte("age-encryption.org/"))
+}
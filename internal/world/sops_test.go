@@ -0,0 +1,133 @@
+package world
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// sopsEncrypt encrypts a single value the same way SOPS does.
+func sopsEncrypt(t *testing.T, key []byte, value, typ, path string) string {
+	iv := make([]byte, 32)
+	_, err := rand.Read(iv)
+	require.NoError(t, err)
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	require.NoError(t, err)
+	sealed := gcm.Seal(nil, iv, []byte(value), []byte(path))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	enc := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]", enc(data), enc(iv), enc(tag), typ)
+}
+
+func ageEncrypt(t *testing.T, recipient age.Recipient, content []byte) []byte {
+	var out bytes.Buffer
+	aw := armor.NewWriter(&out)
+	w, err := age.Encrypt(aw, recipient)
+	require.NoError(t, err)
+	_, err = w.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, aw.Close())
+	return out.Bytes()
+}
+
+func TestEncryptedData(t *testing.T) {
+	dir := t.TempDir()
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	keyFile := filepath.Join(dir, "keys.txt")
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte(identity.String()+"\n"), 0600))
+	t.Setenv(SopsAgeKeyFile, "")
+	t.Setenv(SopsAgeKey, "")
+
+	dataKey := make([]byte, 32)
+	_, err = rand.Read(dataKey)
+	require.NoError(t, err)
+	doc := map[string]interface{}{
+		"db": map[string]interface{}{
+			"password": sopsEncrypt(t, dataKey, "s3cret", "str", "db:password:"),
+			"port":     sopsEncrypt(t, dataKey, "5432", "int", "db:port:"),
+		},
+		"hosts":              []interface{}{sopsEncrypt(t, dataKey, "a.example.org", "str", "hosts:")},
+		"public_unencrypted": "visible",
+		"sops": map[string]interface{}{
+			"age": []interface{}{
+				map[string]interface{}{
+					"recipient": identity.Recipient().String(),
+					"enc":       string(ageEncrypt(t, identity.Recipient(), dataKey)),
+				},
+			},
+			"unencrypted_suffix": "_unencrypted",
+			"version":            "3.7.3",
+		},
+	}
+	yamlDoc, err := yaml.Marshal(doc)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "secrets.enc.yaml"), yamlDoc, 0600))
+	jsonDoc, err := json.Marshal(doc)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "secrets.enc.json"), jsonDoc, 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "plain.yaml.age"), ageEncrypt(t, identity.Recipient(), []byte("greeting: hello\n")), 0600))
+
+	expected := map[string]interface{}{
+		"db":                 map[string]interface{}{"password": "s3cret", "port": 5432},
+		"hosts":              []interface{}{"a.example.org"},
+		"public_unencrypted": "visible",
+	}
+
+	t.Run("sops-yaml", func(t *testing.T) {
+		data, err := LoadDataWithOptions(context.Background(), []string{"s=secrets.enc.yaml"}, dir, &DataOptions{AgeIdentities: []string{keyFile}})
+		require.NoError(t, err)
+		require.Equal(t, expected, data["s"])
+	})
+
+	t.Run("sops-json-key-from-env", func(t *testing.T) {
+		t.Setenv(SopsAgeKeyFile, keyFile)
+		data, err := LoadData(context.Background(), []string{"s=secrets.enc.json"}, dir)
+		require.NoError(t, err)
+		w := New(context.Background(), &Options{})
+		w.Data = data
+		require.Equal(t, "s3cret:5432", requireRender(t, w, `{{ .Data.s.db.password }}:{{ .Data.s.db.port }}`))
+	})
+
+	t.Run("age-file", func(t *testing.T) {
+		data, err := LoadDataWithOptions(context.Background(), []string{"p=plain.yaml.age"}, dir, &DataOptions{AgeIdentities: []string{keyFile}})
+		require.NoError(t, err)
+		w := New(context.Background(), &Options{})
+		w.Data = data
+		require.Equal(t, "hello", requireRender(t, w, `{{ .Data.p.greeting }}`))
+	})
+
+	t.Run("wrong-identity", func(t *testing.T) {
+		other, err := age.GenerateX25519Identity()
+		require.NoError(t, err)
+		otherFile := filepath.Join(dir, "other.txt")
+		require.NoError(t, ioutil.WriteFile(otherFile, []byte(other.String()+"\n"), 0600))
+		_, err = LoadDataWithOptions(context.Background(), []string{"s=secrets.enc.yaml"}, dir, &DataOptions{AgeIdentities: []string{otherFile}})
+		require.Error(t, err)
+	})
+	t.Run("plain-sops-key", func(t *testing.T) {
+		plain := "sops:\n  version: 1\nname: app\n"
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "plain.yaml"), []byte(plain), 0600))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tool.yaml"), []byte("sops:\n  enabled: true\nkey: ENC[x]\n"), 0600))
+		data, err := LoadData(context.Background(), []string{"p=plain.yaml", "t=tool.yaml"}, dir)
+		require.NoError(t, err)
+		require.Equal(t, "app", data["p"].(map[string]interface{})["name"])
+		require.Equal(t, "ENC[x]", data["t"].(map[string]interface{})["key"])
+	})
+}
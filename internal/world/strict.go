@@ -0,0 +1,77 @@
+package world
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// strictIndex works like the builtin index function but fails if a key is
+// missing in a map instead of returning the zero value. It is used in strict
+// mode so that e.g. `index .Env "UNSET"` doesn't silently render nothing.
+func strictIndex(item interface{}, indexes ...interface{}) (interface{}, error) {
+	v := reflect.ValueOf(item)
+	for _, index := range indexes {
+		for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) {
+			if v.IsNil() {
+				return nil, errors.New("index of nil pointer")
+			}
+			v = v.Elem()
+		}
+		if !v.IsValid() {
+			return nil, errors.New("index of untyped nil")
+		}
+		switch v.Kind() {
+		case reflect.Map:
+			key := reflect.ValueOf(index)
+			if !key.IsValid() {
+				return nil, errors.New("index of map with nil key")
+			}
+			if !key.Type().AssignableTo(v.Type().Key()) {
+				if !key.Type().ConvertibleTo(v.Type().Key()) {
+					return nil, errors.Errorf("value has type %s; should be %s", key.Type(), v.Type().Key())
+				}
+				key = key.Convert(v.Type().Key())
+			}
+			value := v.MapIndex(key)
+			if !value.IsValid() {
+				return nil, errors.Errorf("map has no entry for key %s", quoteKey(index))
+			}
+			v = value
+		case reflect.Slice, reflect.Array, reflect.String:
+			i, ok := indexInt(index)
+			if !ok {
+				return nil, errors.Errorf("cannot index %s with %v", v.Type(), index)
+			}
+			if i < 0 || i >= v.Len() {
+				return nil, errors.Errorf("index out of range: %d", i)
+			}
+			v = v.Index(i)
+		default:
+			return nil, errors.Errorf("can't index item of type %s", v.Type())
+		}
+	}
+	if !v.IsValid() {
+		return nil, nil
+	}
+	return v.Interface(), nil
+}
+
+func indexInt(index interface{}) (int, bool) {
+	v := reflect.ValueOf(index)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int(v.Uint()), true
+	}
+	return 0, false
+}
+
+func quoteKey(key interface{}) string {
+	if s, ok := key.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprintf("%v", key)
+}
@@ -0,0 +1,53 @@
+package world
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStrict(t *testing.T) {
+	t.Setenv("TPL_STRICT_TEST", "set")
+	t.Setenv("TPL_STRICT_UNSET", "")
+	os.Unsetenv("TPL_STRICT_UNSET")
+	data := Data{"cfg": map[string]interface{}{"name": "app"}, "list": []interface{}{"a", "b"}}
+	tests := []struct {
+		input  string
+		output string
+		err    string
+	}{
+		{input: `{{ .Data.cfg.name }}`, output: "app"},
+		{input: `{{ index .Data "list" 1 }}`, output: "b"},
+		{input: `{{ index .Env "TPL_STRICT_TEST" }}`, output: "set"},
+		{input: `{{ .Env.TPL_STRICT_TEST }}`, output: "set"},
+		{input: "\n{{ .Data.cfg.tyop }}", err: `template: ROOT:2:8: executing "ROOT" at <.Data.cfg.tyop>: map has no entry for key "tyop"`},
+		{input: `{{ index .Env "TPL_STRICT_UNSET" }}`, err: `map has no entry for key "TPL_STRICT_UNSET"`},
+		{input: `{{ .Env.TPL_STRICT_UNSET }}`, err: `map has no entry for key "TPL_STRICT_UNSET"`},
+		{input: `{{ index .Data "list" 2 }}`, err: "index out of range: 2"},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			w := New(context.Background(), &Options{Strict: true})
+			w.Data = data
+			var out bytes.Buffer
+			err := w.Render(&out, bytes.NewBufferString(test.input))
+			if test.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), test.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.output, out.String())
+		})
+	}
+
+	t.Run("non-strict", func(t *testing.T) {
+		w := New(context.Background(), &Options{})
+		w.Data = data
+		out := requireRender(t, w, `{{ .Data.cfg.tyop }}|{{ index .Env "TPL_STRICT_UNSET" }}`)
+		require.Equal(t, "<no value>|", out)
+	})
+}
@@ -36,10 +36,17 @@ func (sys *System) ShellOutput(cmd string) (string, error) {
 	if !sys.world.insecure {
 		return "", ErrInsecureRequired
 	}
+	if sys.world.shell != nil {
+		output, err := sys.world.shell(cmd)
+		sys.world.recordCommand(cmd, err)
+		return output, err
+	}
 	var output bytes.Buffer
 	c := exec.Command("/bin/bash", "-c", cmd)
 	c.Stdout = &output
-	if err := c.Run(); err != nil {
+	err := c.Run()
+	sys.world.recordCommand(cmd, err)
+	if err != nil {
 		return "", err
 	}
 	return output.String(), nil
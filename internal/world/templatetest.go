@@ -0,0 +1,252 @@
+package world
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// TemplateTestSuffix is the suffix of files containing test cases for a
+// template. config.tpl.test.yaml contains the tests of config.tpl.
+const TemplateTestSuffix = ".tpl.test.yaml"
+
+// TemplateTest contains the test cases of a single template.
+type TemplateTest struct {
+	// Path of the test file.
+	Path string `yaml:"-"`
+
+	// Template is the path of the tested template relative to the test
+	// file. Defaults to the name of the test file without .test.yaml.
+	Template string `yaml:"template"`
+
+	Cases []TemplateTestCase `yaml:"cases"`
+}
+
+// TemplateTestCase describes everything a template can access during a
+// single render together with the file containing the expected output.
+type TemplateTestCase struct {
+	Name string                 `yaml:"name"`
+	Data map[string]interface{} `yaml:"data"`
+
+	// Env contains all the environment variables available to the template.
+	// The process environment is not used.
+	Env map[string]string `yaml:"env"`
+
+	// Secrets maps schemes (e.g. vault or azure) to the secrets they serve.
+	// Keys are references like in secret URIs (path, path#field or
+	// path?version=2#field). Values are either strings or maps of fields.
+	Secrets map[string]map[string]interface{} `yaml:"secrets"`
+
+	// Shell maps commands run through System.ShellOutput to their output.
+	Shell map[string]string `yaml:"shell"`
+
+	// Golden is the path of the file containing the expected output
+	// relative to the test file. Defaults to <template>.<name>.golden.
+	Golden string `yaml:"golden"`
+}
+
+// goldenNamePattern matches the characters replaced in case names when
+// deriving the name of the golden file.
+var goldenNamePattern = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// FindTemplateTests returns the test files found in the given paths.
+// Directories are searched recursively while files are used as they are.
+func FindTemplateTests(paths []string) ([]string, error) {
+	var result []string
+	seen := make(map[string]struct{})
+	add := func(path string) {
+		if _, ok := seen[path]; ok {
+			return
+		}
+		seen[path] = struct{}{}
+		result = append(result, path)
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find tests in %s", path)
+		}
+		if !info.IsDir() {
+			add(path)
+			continue
+		}
+		var found []string
+		err = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && strings.HasSuffix(info.Name(), TemplateTestSuffix) {
+				found = append(found, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find tests in %s", path)
+		}
+		sort.Strings(found)
+		for _, path := range found {
+			add(path)
+		}
+	}
+	return result, nil
+}
+
+// LoadTemplateTest reads the test file at path. Unknown keys are reported
+// as errors in order to catch typos.
+func LoadTemplateTest(path string) (*TemplateTest, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read test %s", path)
+	}
+	test := &TemplateTest{Path: path}
+	if err := yaml.UnmarshalStrict(content, test); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse test %s", path)
+	}
+	if test.Template == "" {
+		test.Template = strings.TrimSuffix(filepath.Base(path), ".test.yaml")
+	}
+	if len(test.Cases) == 0 {
+		return nil, errors.Errorf("test %s contains no cases", path)
+	}
+	names := make(map[string]struct{})
+	for _, c := range test.Cases {
+		if c.Name == "" {
+			return nil, errors.Errorf("test %s contains a case without name", path)
+		}
+		if _, ok := names[c.Name]; ok {
+			return nil, errors.Errorf("test %s contains multiple cases named `%s`", path, c.Name)
+		}
+		names[c.Name] = struct{}{}
+	}
+	return test, nil
+}
+
+// TemplatePath returns the path of the tested template.
+func (t *TemplateTest) TemplatePath() string {
+	return filepath.Join(filepath.Dir(t.Path), t.Template)
+}
+
+// GoldenPath returns the path of the file containing the expected output of
+// the case.
+func (t *TemplateTest) GoldenPath(c TemplateTestCase) string {
+	golden := c.Golden
+	if golden == "" {
+		golden = filepath.Base(t.Template) + "." + goldenNamePattern.ReplaceAllString(c.Name, "-") + ".golden"
+	}
+	return filepath.Join(filepath.Dir(t.Path), golden)
+}
+
+// Render renders the template of the test using the data, environment,
+// secrets and shell output of the case. The world used for that has no
+// access to the process environment, real secret backends or the shell.
+// opts configures everything not covered by the case (e.g. delimiters).
+func (t *TemplateTest) Render(ctx context.Context, c TemplateTestCase, opts *Options) (string, error) {
+	var testOpts Options
+	if opts != nil {
+		testOpts = *opts
+	}
+	testOpts.Env = c.Env
+	testOpts.EnvOnly = true
+	testOpts.SecretCache = ""
+	// Nothing is executed as shell commands are mocked.
+	testOpts.Insecure = true
+	testOpts.Shell = func(cmd string) (string, error) {
+		output, ok := c.Shell[cmd]
+		if !ok {
+			return "", errors.Errorf("no output mocked for command `%s`", cmd)
+		}
+		return output, nil
+	}
+	w := New(ctx, &testOpts)
+	schemes := map[string]struct{}{"vault": {}, "azure": {}, "aws": {}, "ssm": {}}
+	for scheme := range c.Secrets {
+		schemes[scheme] = struct{}{}
+	}
+	for scheme := range schemes {
+		p, err := newMockSecretProvider(c.Secrets[scheme])
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid %s secrets", scheme)
+		}
+		w.RegisterSecretProvider(scheme, func() SecretProvider { return p })
+	}
+	if data, ok := normalizeValue(c.Data).(map[string]interface{}); ok {
+		w.Data = data
+	}
+
+	path := t.TemplatePath()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read template %s", path)
+	}
+	var out bytes.Buffer
+	if err := w.RenderTemplate(&out, bytes.NewReader(content), path); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// mockSecretProvider serves the secrets of a test case.
+type mockSecretProvider struct {
+	secrets map[string]string
+}
+
+// newMockSecretProvider flattens the secrets of a test case. Secrets given
+// as maps of fields are available as path#field and as JSON document.
+func newMockSecretProvider(secrets map[string]interface{}) (*mockSecretProvider, error) {
+	p := &mockSecretProvider{secrets: make(map[string]string)}
+	for ref, value := range secrets {
+		switch v := normalizeValue(value).(type) {
+		case map[string]interface{}:
+			raw, err := json.Marshal(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid secret %s", ref)
+			}
+			p.secrets[ref] = string(raw)
+			for field, fieldValue := range v {
+				if s, ok := fieldValue.(string); ok {
+					p.secrets[ref+"#"+field] = s
+					continue
+				}
+				raw, err := json.Marshal(fieldValue)
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid secret %s#%s", ref, field)
+				}
+				p.secrets[ref+"#"+field] = string(raw)
+			}
+		case string:
+			p.secrets[ref] = v
+		default:
+			raw, err := json.Marshal(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid secret %s", ref)
+			}
+			p.secrets[ref] = string(raw)
+		}
+	}
+	return p, nil
+}
+
+// FetchSecret implements SecretProvider. Fields that are not mocked
+// explicitly are extracted from secrets containing JSON documents like
+// Azure and AWS do.
+func (p *mockSecretProvider) FetchSecret(ref SecretRef) (string, error) {
+	if value, ok := p.secrets[ref.String()]; ok {
+		return value, nil
+	}
+	if ref.Field != "" {
+		doc := SecretRef{Path: ref.Path, Version: ref.Version}
+		if value, ok := p.secrets[doc.String()]; ok {
+			return jsonField(value, ref.Field)
+		}
+	}
+	return "", errors.Errorf("secret %s is not mocked", ref.String())
+}
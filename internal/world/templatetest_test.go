@@ -0,0 +1,106 @@
+package world_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zerok/tpl/internal/world"
+)
+
+func TestTemplateTest(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+	writeFile("app/config.tpl", `name={{ .Data.app.name }}
+home={{ env "HOME" }}
+user={{ .Env.USER }}
+password={{ vault "secret/app" "password" }}
+old={{ vault "secret/app" "password" 1 }}
+db={{ .Azure.Secret "db" }}
+json={{ secret "azure://json#user.name" }}
+host={{ .System.ShellOutput "hostname" }}
+`)
+	testPath := writeFile("app/config.tpl.test.yaml", `cases:
+  - name: production setup
+    data:
+      app:
+        name: web
+    env:
+      HOME: /home/web
+      USER: web
+    secrets:
+      vault:
+        secret/app:
+          password: s3cret
+        secret/app?version=1#password: old
+      azure:
+        db: hunter2
+        json: '{"user": {"name": "admin"}}'
+    shell:
+      hostname: web-1
+  - name: missing
+    golden: missing.out
+`)
+	writeFile("app/other.tpl.test.yaml", "cases: []\n")
+
+	t.Run("find", func(t *testing.T) {
+		paths, err := world.FindTemplateTests([]string{dir, testPath})
+		require.NoError(t, err)
+		require.Equal(t, []string{testPath, filepath.Join(dir, "app/other.tpl.test.yaml")}, paths)
+	})
+
+	t.Run("load", func(t *testing.T) {
+		test, err := world.LoadTemplateTest(testPath)
+		require.NoError(t, err)
+		require.Equal(t, "config.tpl", test.Template)
+		require.Equal(t, filepath.Join(dir, "app/config.tpl"), test.TemplatePath())
+		require.Len(t, test.Cases, 2)
+		require.Equal(t, filepath.Join(dir, "app/config.tpl.production-setup.golden"), test.GoldenPath(test.Cases[0]))
+		require.Equal(t, filepath.Join(dir, "app/missing.out"), test.GoldenPath(test.Cases[1]))
+
+		_, err = world.LoadTemplateTest(filepath.Join(dir, "app/other.tpl.test.yaml"))
+		require.Error(t, err)
+		_, err = world.LoadTemplateTest(writeFile("typo.tpl.test.yaml", "cases:\n  - name: a\n    enviroment: {}\n"))
+		require.Error(t, err)
+	})
+
+	t.Run("render", func(t *testing.T) {
+		t.Setenv("TPL_TEST_OUTSIDE", "leaked")
+		test, err := world.LoadTemplateTest(testPath)
+		require.NoError(t, err)
+
+		out, err := test.Render(context.Background(), test.Cases[0], nil)
+		require.NoError(t, err)
+		require.Equal(t, `name=web
+home=/home/web
+user=web
+password=s3cret
+old=old
+db=hunter2
+json=admin
+host=web-1
+`, out)
+
+		_, err = test.Render(context.Background(), test.Cases[1], nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "secret/app#password is not mocked")
+
+		test.Template = "../outside.tpl"
+		writeFile("outside.tpl", `[[ env "TPL_TEST_OUTSIDE" ]][[ .System.ShellOutput "true" ]]`)
+		_, err = test.Render(context.Background(), test.Cases[0], &world.Options{LeftDelim: "[[", RightDelim: "]]"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "no output mocked for command `true`")
+		test.Cases[0].Shell["true"] = ""
+		out, err = test.Render(context.Background(), test.Cases[0], &world.Options{LeftDelim: "[[", RightDelim: "]]"})
+		require.NoError(t, err)
+		require.Equal(t, "", out)
+	})
+}
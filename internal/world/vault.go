@@ -2,8 +2,12 @@ package world
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
@@ -19,61 +23,279 @@ func (w *World) Vault() *Vault {
 	ctx := logger.WithContext(w.ctx)
 	var client *vault.Client
 	var err error
-	var token string
 	vaultConfig := &vault.Config{}
-	if err := vaultConfig.ReadEnvironment(); err != nil {
+	if !w.envOnly {
+		if err := vaultConfig.ReadEnvironment(); err != nil {
+			logger.Warn().Msgf("Failed to read Vault configuration: %s", err.Error())
+		}
+	}
+	if err := applyVaultEnvironment(vaultConfig, w.getenv); err != nil {
 		logger.Warn().Msgf("Failed to read Vault configuration: %s", err.Error())
 	}
+	method := w.vaultAuth
+	if method == "" {
+		method = w.getenv(VaultAuthMethodEnv)
+	}
+	mount := w.vaultAuthMount
+	if mount == "" {
+		mount = w.getenv(VaultAuthMountEnv)
+	}
+	auth, authErr := newVaultAuthenticator(method, mount, w.getenv)
+	if authErr != nil {
+		logger.Warn().Msgf("Vault authentication not possible: %s", authErr.Error())
+	}
 	client, err = vault.NewClient(vaultConfig)
 	if err == nil {
-		token = os.Getenv("VAULT_TOKEN")
-		if token == "" {
+		if w.envOnly {
+			// NewClient picks up the token and namespace from the process
+			// environment on its own.
+			client.SetToken(w.getenv(VaultTokenEnv))
+			headers := client.Headers()
+			headers.Del("X-Vault-Namespace")
+			client.SetHeaders(headers)
+		}
+		if namespace := w.getenv(vault.EnvVaultNamespace); namespace != "" {
+			client.SetNamespace(namespace)
+		}
+		if (method == "" || method == VaultAuthToken) && w.getenv(VaultTokenEnv) == "" {
 			logger.Warn().Msgf("VAULT_TOKEN not set. Vault not available.")
-		} else {
-			client.SetToken(token)
 		}
 	} else {
-		if token == "" {
-			logger.Warn().Msgf("Failed to create Vault client: %s", err.Error())
-		}
+		logger.Warn().Msgf("Failed to create Vault client: %s", err.Error())
+	}
+	if err == nil && authErr != nil {
+		err = authErr
 	}
 
 	w.vault = &Vault{
-		ctx:        ctx,
-		client:     client,
-		err:        err,
-		KeyMapping: make(map[string]string),
+		ctx:         ctx,
+		world:       w,
+		client:      client,
+		err:         err,
+		auth:        auth,
+		PathMapping: PathMapping{KeyMapping: make(map[string]string)},
+		mounts:      make(map[string]int),
 	}
 	return w.vault
 }
 
+// applyVaultEnvironment applies the Vault address and TLS settings found
+// through getenv. This makes it possible to configure Vault using env files
+// in addition to the process environment.
+func applyVaultEnvironment(config *vault.Config, getenv func(string) string) error {
+	if addr := getenv(vault.EnvVaultAddress); addr != "" {
+		config.Address = addr
+	}
+	tls := &vault.TLSConfig{
+		CACert:        getenv(vault.EnvVaultCACert),
+		CAPath:        getenv(vault.EnvVaultCAPath),
+		ClientCert:    getenv(vault.EnvVaultClientCert),
+		ClientKey:     getenv(vault.EnvVaultClientKey),
+		TLSServerName: getenv(vault.EnvVaultTLSServerName),
+	}
+	if skip := getenv(vault.EnvVaultSkipVerify); skip != "" {
+		insecure, err := strconv.ParseBool(skip)
+		if err != nil {
+			return errors.Wrapf(err, "invalid value for %s", vault.EnvVaultSkipVerify)
+		}
+		tls.Insecure = insecure
+	}
+	if *tls == (vault.TLSConfig{}) {
+		return nil
+	}
+	return config.ConfigureTLS(tls)
+}
+
 type Vault struct {
-	ctx        context.Context
-	client     *vault.Client
-	err        error
-	Prefix     string
-	KeyMapping map[string]string
+	PathMapping
+	ctx    context.Context
+	world  *World
+	client *vault.Client
+	err    error
+
+	// mu guards the authentication state as well as the mounts.
+	mu sync.Mutex
+
+	// mounts maps already detected mount paths to the version of the KV
+	// engine mounted there.
+	mounts map[string]int
+
+	auth      VaultAuthenticator
+	loggedIn  bool
+	renewable bool
+	renewAt   time.Time
+}
+
+// Secret returns the given field of the secret stored at path. Paths on KV
+// version 2 mounts are rewritten transparently. An optional version can be
+// passed in order to pin a specific version of a KV version 2 secret.
+func (v *Vault) Secret(path, field string, version ...int) (string, error) {
+	if len(version) > 1 {
+		return "", errors.New("only a single version can be requested")
+	}
+	ref := SecretRef{Path: path, Field: field}
+	if len(version) == 1 {
+		ref.Version = strconv.Itoa(version[0])
+	}
+	return v.world.lookupSecret("vault", ref)
 }
 
-func (v *Vault) Secret(path, field string) (string, error) {
+func (v *Vault) cacheScope() string {
 	if v.client == nil {
-		return "", errors.New("no vault client available")
+		return ""
 	}
-	prefixPath := fmt.Sprintf("%s%s", v.Prefix, path)
-	mapped, ok := v.KeyMapping[prefixPath]
+	identity, ok := vaultAuthIdentity(v.auth, v.client)
 	if !ok {
-		mapped = path
+		return ""
 	}
-	sec, err := v.client.Logical().Read(mapped)
+	return v.client.Address() + "|" + v.client.Headers().Get("X-Vault-Namespace") + "|" + identity
+}
+
+// FetchSecret implements SecretProvider. Without a field, all fields of the
+// secret are returned as JSON document.
+func (v *Vault) FetchSecret(ref SecretRef) (string, error) {
+	value, _, err := v.fetchLeasedSecret(ref)
+	return value, err
+}
+
+// fetchLeasedSecret implements leasedProvider.
+func (v *Vault) fetchLeasedSecret(ref SecretRef) (string, time.Duration, error) {
+	if v.client == nil {
+		return "", 0, errors.New("no vault client available")
+	}
+	if v.err != nil {
+		return "", 0, v.err
+	}
+	mapped, field := ref.Path, ref.Field
+	mount, kvVersion, err := v.prepare(mapped)
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to access Vault path %s", mapped)
+		return "", 0, err
 	}
-	if sec == nil {
-		return "", errors.Errorf("Vault path %s contained no secret", mapped)
+	readPath := mapped
+	var params map[string][]string
+	if kvVersion == 2 {
+		readPath = kv2DataPath(mount, mapped)
+		if ref.Version != "" {
+			params = map[string][]string{"version": {ref.Version}}
+		}
+	} else if ref.Version != "" {
+		return "", 0, errors.Errorf("Vault path %s is not on a KV version 2 mount and cannot be versioned", mapped)
+	}
+	data, lease, err := v.read(mapped, readPath, params, kvVersion)
+	if err != nil {
+		return "", 0, err
+	}
+	if field != "" {
+		value, err := vaultField(mapped, data, field)
+		return value, lease, err
 	}
-	raw, ok := sec.Data[field]
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "failed to encode Vault secret %s", mapped)
+	}
+	return string(raw), lease, nil
+}
+
+// ExtractField returns the field of a secret returned by FetchSecret.
+func (v *Vault) ExtractField(document string, ref SecretRef) (string, error) {
+	dec := json.NewDecoder(strings.NewReader(document))
+	dec.UseNumber()
+	var data map[string]interface{}
+	if err := dec.Decode(&data); err != nil {
+		return "", errors.Wrapf(err, "failed to decode Vault secret %s", ref.Path)
+	}
+	return vaultField(ref.Path, data, ref.Field)
+}
+
+func vaultField(path string, data map[string]interface{}, field string) (string, error) {
+	raw, ok := data[field]
 	if !ok {
-		return "", errors.Errorf("%s has no field named '%s'", mapped, field)
+		return "", errors.Errorf("%s has no field named '%s'", path, field)
 	}
 	return fmt.Sprintf("%s", raw), nil
 }
+
+// prepare makes sure that the client is authenticated and detects the mount
+// of the given path. Concurrent lookups are serialized here.
+func (v *Vault) prepare(path string) (string, int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := v.authenticate(); err != nil {
+		return "", 0, err
+	}
+	mount, kvVersion, err := v.mountInfo(path)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "failed to detect mount of Vault path %s", path)
+	}
+	return mount, kvVersion, nil
+}
+
+// read returns the data stored at readPath which is the API location of the
+// mapped path together with its lease duration (0 if it doesn't expire).
+func (v *Vault) read(mapped, readPath string, params map[string][]string, kvVersion int) (map[string]interface{}, time.Duration, error) {
+	sec, err := v.client.Logical().ReadWithData(readPath, params)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to access Vault path %s", mapped)
+	}
+	if sec == nil {
+		return nil, 0, errors.Errorf("Vault path %s contained no secret", mapped)
+	}
+	lease := time.Duration(sec.LeaseDuration) * time.Second
+	if lease > 0 {
+		v.world.recordSecretTTL(lease)
+	}
+	data := sec.Data
+	if kvVersion == 2 {
+		nested, ok := sec.Data["data"].(map[string]interface{})
+		if !ok {
+			return nil, 0, errors.Errorf("Vault path %s contained no data (deleted or destroyed version?)", mapped)
+		}
+		data = nested
+	}
+	return data, lease, nil
+}
+
+// mountInfo determines the mount path and KV engine version for the given
+// path. Vault instances that do not allow access to the mounts endpoint are
+// treated as KV version 1.
+func (v *Vault) mountInfo(path string) (string, int, error) {
+	var known string
+	for mount := range v.mounts {
+		if strings.HasPrefix(path, mount) && len(mount) > len(known) {
+			known = mount
+		}
+	}
+	if known != "" {
+		return known, v.mounts[known], nil
+	}
+	logger := zerolog.Ctx(v.ctx)
+	sec, err := v.client.Logical().Read("sys/internal/ui/mounts/" + path)
+	if err != nil {
+		if respErr, ok := err.(*vault.ResponseError); ok && (respErr.StatusCode == 403 || respErr.StatusCode == 404) {
+			logger.Debug().Msgf("Mount detection for %s not possible. Assuming KV version 1.", path)
+			return "", 1, nil
+		}
+		return "", 0, err
+	}
+	if sec == nil || sec.Data == nil {
+		return "", 1, nil
+	}
+	mount, _ := sec.Data["path"].(string)
+	version := 1
+	if options, ok := sec.Data["options"].(map[string]interface{}); ok {
+		if raw, ok := options["version"].(string); ok && raw == "2" {
+			version = 2
+		}
+	}
+	if mount != "" {
+		logger.Debug().Msgf("Detected KV version %d mounted at %s", version, mount)
+		v.mounts[mount] = version
+	}
+	return mount, version, nil
+}
+
+// kv2DataPath inserts the data/ segment required by KV version 2 mounts
+// after the mount path.
+func kv2DataPath(mount, path string) string {
+	return mount + "data/" + strings.TrimPrefix(path, mount)
+}
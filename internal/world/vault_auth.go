@@ -0,0 +1,248 @@
+package world
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// Names of the supported Vault authentication methods.
+const (
+	VaultAuthToken      string = "token"
+	VaultAuthTokenFile  string = "token-file"
+	VaultAuthAppRole    string = "approle"
+	VaultAuthUserpass   string = "userpass"
+	VaultAuthKubernetes string = "kubernetes"
+	VaultAuthJWT        string = "jwt"
+)
+
+// Environment variables used to configure the Vault authentication methods.
+const (
+	VaultAuthMethodEnv string = "VAULT_AUTH_METHOD"
+	VaultAuthMountEnv  string = "VAULT_AUTH_MOUNT"
+	VaultTokenEnv      string = "VAULT_TOKEN"
+	VaultTokenFileEnv  string = "VAULT_TOKEN_FILE"
+	VaultRoleIDEnv     string = "VAULT_ROLE_ID"
+	VaultSecretIDEnv   string = "VAULT_SECRET_ID"
+	VaultUsernameEnv   string = "VAULT_USERNAME"
+	VaultPasswordEnv   string = "VAULT_PASSWORD"
+	VaultRoleEnv       string = "VAULT_ROLE"
+	VaultJWTEnv        string = "VAULT_JWT"
+	VaultJWTFileEnv    string = "VAULT_JWT_FILE"
+
+	kubernetesServiceAccountToken string = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// VaultAuthenticator retrieves a token for the given client. The returned
+// secret is used to determine if and when the token has to be renewed.
+type VaultAuthenticator interface {
+	Login(client *vault.Client) (*vault.Secret, error)
+}
+
+// newVaultAuthenticator creates the authenticator for the given method.
+// Credentials are read from the environment.
+func newVaultAuthenticator(method, mount string, getenv func(string) string) (VaultAuthenticator, error) {
+	if mount == "" {
+		mount = method
+	}
+	switch method {
+	case "", VaultAuthToken:
+		return &vaultTokenAuth{token: getenv(VaultTokenEnv)}, nil
+	case VaultAuthTokenFile:
+		path := getenv(VaultTokenFileEnv)
+		if path == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to determine home directory")
+			}
+			path = filepath.Join(home, ".vault-token")
+		}
+		return &vaultTokenFileAuth{path: path}, nil
+	case VaultAuthAppRole:
+		return &vaultLoginAuth{
+			path: fmt.Sprintf("auth/%s/login", mount),
+			data: map[string]interface{}{
+				"role_id":   getenv(VaultRoleIDEnv),
+				"secret_id": getenv(VaultSecretIDEnv),
+			},
+		}, nil
+	case VaultAuthUserpass:
+		return &vaultLoginAuth{
+			path: fmt.Sprintf("auth/%s/login/%s", mount, getenv(VaultUsernameEnv)),
+			data: map[string]interface{}{
+				"password": getenv(VaultPasswordEnv),
+			},
+		}, nil
+	case VaultAuthKubernetes:
+		jwtFile := getenv(VaultJWTFileEnv)
+		if jwtFile == "" {
+			jwtFile = kubernetesServiceAccountToken
+		}
+		return &vaultLoginAuth{
+			path:    fmt.Sprintf("auth/%s/login", mount),
+			data:    map[string]interface{}{"role": getenv(VaultRoleEnv)},
+			jwtFile: jwtFile,
+		}, nil
+	case VaultAuthJWT:
+		auth := &vaultLoginAuth{
+			path:    fmt.Sprintf("auth/%s/login", mount),
+			data:    map[string]interface{}{"role": getenv(VaultRoleEnv)},
+			jwtFile: getenv(VaultJWTFileEnv),
+		}
+		if auth.jwtFile == "" {
+			auth.data["jwt"] = getenv(VaultJWTEnv)
+		}
+		return auth, nil
+	default:
+		return nil, errors.Errorf("unsupported Vault auth method '%s'", method)
+	}
+}
+
+// vaultTokenAuth uses a static token (usually coming from VAULT_TOKEN).
+type vaultTokenAuth struct {
+	token string
+}
+
+func (a *vaultTokenAuth) Login(client *vault.Client) (*vault.Secret, error) {
+	if a.token == "" {
+		return nil, nil
+	}
+	return lookupVaultToken(client, a.token), nil
+}
+
+// vaultTokenFileAuth reads the token from a file like the one created by
+// `vault login`.
+type vaultTokenFileAuth struct {
+	path string
+}
+
+func (a *vaultTokenFileAuth) Login(client *vault.Client) (*vault.Secret, error) {
+	raw, err := ioutil.ReadFile(a.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read token file %s", a.path)
+	}
+	token := strings.TrimSpace(string(raw))
+	if token == "" {
+		return nil, errors.Errorf("token file %s is empty", a.path)
+	}
+	return lookupVaultToken(client, token), nil
+}
+
+// lookupVaultToken sets the token on the client and tries to determine its
+// TTL so that it can be renewed later on. Tokens that are not allowed to look
+// themselves up are simply used as they are.
+func lookupVaultToken(client *vault.Client, token string) *vault.Secret {
+	client.SetToken(token)
+	sec, err := client.Auth().Token().LookupSelf()
+	if err != nil || sec == nil {
+		return nil
+	}
+	return sec
+}
+
+// vaultLoginAuth handles all the auth methods that exchange credentials for
+// a token by writing to a login endpoint.
+type vaultLoginAuth struct {
+	path    string
+	data    map[string]interface{}
+	jwtFile string
+}
+
+func (a *vaultLoginAuth) Login(client *vault.Client) (*vault.Secret, error) {
+	data := make(map[string]interface{})
+	for k, v := range a.data {
+		data[k] = v
+	}
+	if a.jwtFile != "" {
+		raw, err := ioutil.ReadFile(a.jwtFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read JWT from %s", a.jwtFile)
+		}
+		data["jwt"] = strings.TrimSpace(string(raw))
+	}
+	client.ClearToken()
+	sec, err := client.Logical().Write(a.path, data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "login at %s failed", a.path)
+	}
+	if sec == nil || sec.Auth == nil || sec.Auth.ClientToken == "" {
+		return nil, errors.Errorf("login at %s returned no token", a.path)
+	}
+	client.SetToken(sec.Auth.ClientToken)
+	return sec, nil
+}
+
+// vaultAuthIdentity returns a hash of the token or of the role and user the
+// authenticator logs in as. It is used to keep cached secrets of different
+// identities apart. ok is false if the identity can't be determined.
+func vaultAuthIdentity(auth VaultAuthenticator, client *vault.Client) (string, bool) {
+	switch a := auth.(type) {
+	case *vaultTokenAuth:
+		token := a.token
+		if token == "" {
+			token = client.Token()
+		}
+		return cacheIdentity(VaultAuthToken, token), true
+	case *vaultTokenFileAuth:
+		raw, err := ioutil.ReadFile(a.path)
+		if err != nil {
+			return "", false
+		}
+		return cacheIdentity(VaultAuthToken, strings.TrimSpace(string(raw))), true
+	case *vaultLoginAuth:
+		role, _ := a.data["role"].(string)
+		roleID, _ := a.data["role_id"].(string)
+		return cacheIdentity(a.path, role, roleID), true
+	default:
+		return "", false
+	}
+}
+
+// authenticate logs into Vault on first use and renews the token once two
+// thirds of its TTL have passed. If the renewal fails, a new login is
+// attempted.
+func (v *Vault) authenticate() error {
+	if v.auth == nil {
+		return nil
+	}
+	if v.loggedIn && (v.renewAt.IsZero() || time.Now().Before(v.renewAt)) {
+		return nil
+	}
+	if v.loggedIn && v.renewable {
+		sec, err := v.client.Auth().Token().RenewSelf(0)
+		if err == nil {
+			v.scheduleRenewal(sec)
+			return nil
+		}
+		zerolog.Ctx(v.ctx).Warn().Err(err).Msg("Failed to renew Vault token. Trying to log in again.")
+	}
+	sec, err := v.auth.Login(v.client)
+	if err != nil {
+		return errors.Wrap(err, "failed to authenticate against Vault")
+	}
+	v.loggedIn = true
+	v.scheduleRenewal(sec)
+	return nil
+}
+
+func (v *Vault) scheduleRenewal(sec *vault.Secret) {
+	v.renewAt = time.Time{}
+	v.renewable = false
+	if sec == nil {
+		return
+	}
+	ttl, err := sec.TokenTTL()
+	if err != nil || ttl <= 0 {
+		return
+	}
+	renewable, _ := sec.TokenIsRenewable()
+	v.renewable = renewable
+	v.renewAt = time.Now().Add(ttl * 2 / 3)
+}
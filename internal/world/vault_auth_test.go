@@ -0,0 +1,144 @@
+package world
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVaultAuth is a Vault stand-in that hands out a token for every login
+// endpoint and only serves secrets to requests carrying that token.
+type fakeVaultAuth struct {
+	logins   map[string]map[string]interface{}
+	renewals int
+}
+
+func (f *fakeVaultAuth) start(t *testing.T) *httptest.Server {
+	f.logins = make(map[string]map[string]interface{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/auth/token/lookup-self":
+			if r.Header.Get("X-Vault-Token") != "file-token" {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `{"errors": ["permission denied"]}`)
+				return
+			}
+			fmt.Fprint(w, `{"data": {"ttl": 3600, "renewable": true}}`)
+		case "/v1/auth/token/renew-self":
+			f.renewals++
+			fmt.Fprint(w, `{"auth": {"client_token": "issued-token", "lease_duration": 3600, "renewable": true}}`)
+		case "/v1/kv/app", "/v1/kv/other":
+			token := r.Header.Get("X-Vault-Token")
+			if token != "issued-token" && token != "file-token" {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `{"errors": ["permission denied"]}`)
+				return
+			}
+			fmt.Fprint(w, `{"data": {"password": "pw", "user": "app"}}`)
+		default:
+			if r.Method != http.MethodPut && r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"errors": []}`)
+				return
+			}
+			body := make(map[string]interface{})
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			f.logins[r.URL.Path] = body
+			fmt.Fprint(w, `{"auth": {"client_token": "issued-token", "lease_duration": 3600, "renewable": true}}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVaultAuthMethods(t *testing.T) {
+	fake := &fakeVaultAuth{}
+	srv := fake.start(t)
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv(VaultTokenEnv, "")
+	t.Setenv(VaultRoleIDEnv, "role-id")
+	t.Setenv(VaultSecretIDEnv, "secret-id")
+	t.Setenv(VaultUsernameEnv, "alice")
+	t.Setenv(VaultPasswordEnv, "wonderland")
+	t.Setenv(VaultRoleEnv, "deployer")
+	t.Setenv(VaultJWTEnv, "inline-jwt")
+	dir := t.TempDir()
+	jwtFile := filepath.Join(dir, "sa-token")
+	require.NoError(t, ioutil.WriteFile(jwtFile, []byte("file-jwt\n"), 0600))
+
+	tests := []struct {
+		method    string
+		mount     string
+		jwtFile   string
+		loginPath string
+		expected  map[string]interface{}
+	}{
+		{
+			method:    VaultAuthAppRole,
+			loginPath: "/v1/auth/approle/login",
+			expected:  map[string]interface{}{"role_id": "role-id", "secret_id": "secret-id"},
+		},
+		{
+			method:    VaultAuthUserpass,
+			mount:     "ldap-users",
+			loginPath: "/v1/auth/ldap-users/login/alice",
+			expected:  map[string]interface{}{"password": "wonderland"},
+		},
+		{
+			method:    VaultAuthKubernetes,
+			jwtFile:   jwtFile,
+			loginPath: "/v1/auth/kubernetes/login",
+			expected:  map[string]interface{}{"role": "deployer", "jwt": "file-jwt"},
+		},
+		{
+			method:    VaultAuthJWT,
+			loginPath: "/v1/auth/jwt/login",
+			expected:  map[string]interface{}{"role": "deployer", "jwt": "inline-jwt"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.method, func(t *testing.T) {
+			t.Setenv(VaultJWTFileEnv, test.jwtFile)
+			w := New(context.Background(), &Options{VaultAuth: test.method, VaultAuthMount: test.mount})
+			out := requireRender(t, w, `{{ vault "kv/app" "password" }}`)
+			require.Equal(t, "pw", out)
+			require.Equal(t, test.expected, fake.logins[test.loginPath])
+		})
+	}
+
+	t.Run(VaultAuthTokenFile, func(t *testing.T) {
+		tokenFile := filepath.Join(dir, ".vault-token")
+		require.NoError(t, ioutil.WriteFile(tokenFile, []byte("file-token\n"), 0600))
+		t.Setenv(VaultTokenFileEnv, tokenFile)
+		w := New(context.Background(), &Options{VaultAuth: VaultAuthTokenFile})
+		out := requireRender(t, w, `{{ vault "kv/app" "password" }}`)
+		require.Equal(t, "pw", out)
+		require.False(t, w.Vault().renewAt.IsZero())
+	})
+
+	t.Run("unknown-method", func(t *testing.T) {
+		w := New(context.Background(), &Options{VaultAuth: "carrier-pigeon"})
+		requireError(t, w, `{{ vault "kv/app" "password" }}`)
+	})
+
+	t.Run("renewal", func(t *testing.T) {
+		w := New(context.Background(), &Options{VaultAuth: VaultAuthAppRole})
+		requireRender(t, w, `{{ vault "kv/app" "password" }}`)
+		require.Equal(t, 0, fake.renewals)
+		w.Vault().renewAt = time.Now().Add(-time.Second)
+		requireRender(t, w, `{{ vault "kv/other" "user" }}`)
+		require.Equal(t, 1, fake.renewals)
+	})
+}
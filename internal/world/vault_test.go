@@ -3,8 +3,13 @@ package world_test
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/zerok/tpl/internal/world"
@@ -20,3 +25,116 @@ func TestVaultSecret(t *testing.T) {
 	t.Logf("[[[ %s ]]]", out.String())
 	require.Error(t, err)
 }
+
+// newFakeVault starts a minimal Vault stand-in that serves a KV version 1
+// mount at kv/ and a KV version 2 mount at secret/.
+func newFakeVault(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(fakeVault)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// fakeVault handles the requests of newFakeVault.
+var fakeVault = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v1/sys/internal/ui/mounts/secret/"):
+		fmt.Fprint(w, `{"data": {"path": "secret/", "type": "kv", "options": {"version": "2"}}}`)
+	case strings.HasPrefix(r.URL.Path, "/v1/sys/internal/ui/mounts/kv/"):
+		fmt.Fprint(w, `{"data": {"path": "kv/", "type": "kv", "options": null}}`)
+	case r.URL.Path == "/v1/secret/data/app":
+		version := r.URL.Query().Get("version")
+		if version == "" {
+			version = "3"
+		}
+		fmt.Fprintf(w, `{"data": {"data": {"password": "v2-pw-%s"}, "metadata": {"version": %s}}}`, version, version)
+	case r.URL.Path == "/v1/kv/app":
+		fmt.Fprint(w, `{"lease_duration": 3600, "data": {"username": "admin", "password": "v1-pw"}}`)
+	case r.URL.Path == "/v1/kv/short-lease":
+		fmt.Fprint(w, `{"lease_duration": 1, "data": {"password": "short-pw"}}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"errors": []}`)
+	}
+})
+
+func TestVaultKVVersions(t *testing.T) {
+	srv := newFakeVault(t)
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "test-token")
+	tests := []struct {
+		input   string
+		output  string
+		errored bool
+	}{
+		{input: `{{ vault "kv/app" "password" }}`, output: "v1-pw"},
+		{input: `{{ vault "secret/app" "password" }}`, output: "v2-pw-3"},
+		{input: `{{ vault "secret/app" "password" 1 }}`, output: "v2-pw-1"},
+		{input: `{{ .Vault.Secret "secret/app" "password" 2 }}`, output: "v2-pw-2"},
+		{input: `{{ vault "kv/app" "password" 1 }}`, errored: true},
+		{input: `{{ vault "secret/app" "missing" }}`, errored: true},
+		{input: `{{ vault "secret/other" "password" }}`, errored: true},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			w := world.New(context.Background(), nil)
+			var out bytes.Buffer
+			err := w.Render(&out, bytes.NewBufferString(test.input))
+			if test.errored {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.output, out.String())
+		})
+	}
+}
+
+func TestVaultSecretTTL(t *testing.T) {
+	srv := newFakeVault(t)
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "test-token")
+	w := world.New(context.Background(), nil)
+	var out bytes.Buffer
+	require.NoError(t, w.Render(&out, bytes.NewBufferString(`{{ vault "secret/app" "password" }}`)))
+	require.Equal(t, time.Duration(0), w.SecretTTL())
+	require.NoError(t, w.Render(&out, bytes.NewBufferString(`{{ vault "kv/app" "password" }}`)))
+	require.Equal(t, time.Hour, w.SecretTTL())
+}
+
+func TestVaultEnvOptions(t *testing.T) {
+	srv := newFakeVault(t)
+	t.Setenv("VAULT_ADDR", "http://127.0.0.1:1")
+	t.Setenv("VAULT_TOKEN", "")
+	w := world.New(context.Background(), &world.Options{
+		Env: map[string]string{"VAULT_ADDR": srv.URL, "VAULT_TOKEN": "test-token"},
+	})
+	var out bytes.Buffer
+	require.NoError(t, w.Render(&out, bytes.NewBufferString(`{{ vault "kv/app" "password" }}`)))
+	require.Equal(t, "v1-pw", out.String())
+}
+
+func TestVaultEnvOnly(t *testing.T) {
+	var tokens []string
+	var namespaces []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("X-Vault-Token"))
+		namespaces = append(namespaces, r.Header.Get("X-Vault-Namespace"))
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"errors": ["permission denied"]}`)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("VAULT_TOKEN", "process-token")
+	t.Setenv("VAULT_NAMESPACE", "process-namespace")
+	w := world.New(context.Background(), &world.Options{
+		Env:     map[string]string{"VAULT_ADDR": srv.URL},
+		EnvOnly: true,
+	})
+	var out bytes.Buffer
+	require.Error(t, w.Render(&out, bytes.NewBufferString(`{{ vault "kv/app" "password" }}`)))
+	require.NotEmpty(t, tokens)
+	for i := range tokens {
+		require.Empty(t, tokens[i], "the process token is not sent")
+		require.Empty(t, namespaces[i], "the process namespace is not sent")
+	}
+}
@@ -0,0 +1,249 @@
+package world
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// walkNodes calls fn for the given node and all the nodes below it in
+// depth-first order.
+func walkNodes(node parse.Node, fn func(parse.Node)) {
+	walkScopedNodes(node, false, func(node parse.Node, rebound bool) {
+		fn(node)
+	})
+}
+
+// walkScopedNodes works like walkNodes but additionally tells fn whether
+// the dot has been rebound by an enclosing range or with block at the
+// node. The else branches of these blocks keep the dot of their parent.
+func walkScopedNodes(node parse.Node, rebound bool, fn func(node parse.Node, rebound bool)) {
+	if node == nil {
+		return
+	}
+	fn(node, rebound)
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkScopedNodes(child, rebound, fn)
+		}
+	case *parse.ActionNode:
+		walkScopedNodes(n.Pipe, rebound, fn)
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, rebound, rebound, fn)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, rebound, true, fn)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, rebound, true, fn)
+	case *parse.TemplateNode:
+		if n.Pipe != nil {
+			walkScopedNodes(n.Pipe, rebound, fn)
+		}
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, decl := range n.Decl {
+			walkScopedNodes(decl, rebound, fn)
+		}
+		for _, cmd := range n.Cmds {
+			walkScopedNodes(cmd, rebound, fn)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			walkScopedNodes(arg, rebound, fn)
+		}
+	case *parse.ChainNode:
+		walkScopedNodes(n.Node, rebound, fn)
+	}
+}
+
+// walkBranch walks the pipeline and the else branch of n with the dot of
+// the parent and its list with the dot of the block.
+func walkBranch(n *parse.BranchNode, rebound, listRebound bool, fn func(node parse.Node, rebound bool)) {
+	walkScopedNodes(n.Pipe, rebound, fn)
+	if n.List != nil {
+		walkScopedNodes(n.List, listRebound, fn)
+	}
+	if n.ElseList != nil {
+		walkScopedNodes(n.ElseList, rebound, fn)
+	}
+}
+
+// templateCall is a command found in a template that calls a function or a
+// method of the world.
+type templateCall struct {
+	// name is either the name of a function (e.g. vault) or the path of a
+	// method below the world (e.g. Vault.Secret).
+	name string
+	args []parse.Node
+
+	// piped is set if the command receives the result of the previous
+	// command of its pipeline as additional argument.
+	piped bool
+
+	// rebound is set for methods reached through . inside of a range or
+	// with block as well as for methods reached through . or $ inside of
+	// templates other than the main one (e.g. defined using define). The
+	// dot may be anything there, so the call can't be attributed to the
+	// world.
+	rebound bool
+
+	cmd  *parse.CommandNode
+	tree *parse.Tree
+}
+
+// location returns the position of the call as name:line:column.
+func (c templateCall) location() string {
+	return nodeLocation(c.tree, c.cmd)
+}
+
+// nodePosition returns the name of the template as well as the line and
+// column (starting at 0 like in the errors of text/template) of the node.
+// Unlike parse.Tree.ErrorContext, it returns the start of field chains like
+// .Data.app.name instead of the position of their second element.
+func nodePosition(tree *parse.Tree, node parse.Node) (string, int, int) {
+	location, _ := tree.ErrorContext(node)
+	elems := strings.Split(location, ":")
+	if len(elems) < 3 {
+		return location, 0, 0
+	}
+	name := strings.Join(elems[:len(elems)-2], ":")
+	line, _ := strconv.Atoi(elems[len(elems)-2])
+	col, _ := strconv.Atoi(elems[len(elems)-1])
+	switch n := node.(type) {
+	case *parse.FieldNode:
+		if len(n.Ident) > 1 {
+			col -= len(n.Ident[0]) + 1
+		}
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 {
+			col -= len(n.Ident[0])
+		}
+	}
+	return name, line, col
+}
+
+// nodeLocation returns the position of the node as name:line:column.
+func nodeLocation(tree *parse.Tree, node parse.Node) string {
+	name, line, col := nodePosition(tree, node)
+	return fmt.Sprintf("%s:%d:%d", name, line, col)
+}
+
+// walkCalls calls fn for every command in the templates of the set that calls
+// a function or a method reachable through . or $.
+func walkCalls(tmpl *template.Template, fn func(templateCall)) {
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil || t.Tree.Root == nil {
+			continue
+		}
+		tree := t.Tree
+		// Both . and $ of other templates are set by the template action
+		// invoking them.
+		defined := t.Name() != tmpl.Name()
+		walkScopedNodes(tree.Root, defined, func(node parse.Node, rebound bool) {
+			pipe, ok := node.(*parse.PipeNode)
+			if !ok {
+				return
+			}
+			for i, cmd := range pipe.Cmds {
+				if len(cmd.Args) == 0 {
+					continue
+				}
+				var name string
+				callRebound := false
+				switch n := cmd.Args[0].(type) {
+				case *parse.IdentifierNode:
+					name = n.Ident
+				case *parse.FieldNode:
+					name = strings.Join(n.Ident, ".")
+					callRebound = rebound
+				case *parse.VariableNode:
+					if len(n.Ident) < 2 || n.Ident[0] != "$" {
+						continue
+					}
+					name = strings.Join(n.Ident[1:], ".")
+					callRebound = defined
+				default:
+					continue
+				}
+				fn(templateCall{name: name, args: cmd.Args[1:], piped: i > 0, rebound: callRebound, cmd: cmd, tree: tree})
+			}
+		})
+	}
+}
+
+// walkFields calls fn for every field chain starting at . or $ (e.g.
+// .Env.HOME or $.Data.app) in the templates of the set.
+func walkFields(tmpl *template.Template, fn func(ident []string, node parse.Node, tree *parse.Tree)) {
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil || t.Tree.Root == nil {
+			continue
+		}
+		walkTreeFields(t.Tree, fn)
+	}
+}
+
+// walkTreeFields works like walkFields for a single tree. Constant keys of
+// index calls are treated like fields so that index .Env "HOME" is reported
+// as .Env.HOME.
+func walkTreeFields(tree *parse.Tree, fn func(ident []string, node parse.Node, tree *parse.Tree)) {
+	handled := make(map[parse.Node]bool)
+	walkNodes(tree.Root, func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.CommandNode:
+			if len(n.Args) < 3 {
+				return
+			}
+			if fn, ok := n.Args[0].(*parse.IdentifierNode); !ok || fn.Ident != "index" {
+				return
+			}
+			ident := fieldIdent(n.Args[1])
+			keys, ok := stringArgs(n.Args[2:])
+			if ident == nil || !ok {
+				return
+			}
+			handled[n.Args[1]] = true
+			fn(append(ident, keys...), n, tree)
+		case *parse.FieldNode, *parse.VariableNode:
+			if handled[node] {
+				return
+			}
+			if ident := fieldIdent(node); ident != nil {
+				fn(ident, node, tree)
+			}
+		}
+	})
+}
+
+// fieldIdent returns the fields accessed by a field or $ variable node.
+func fieldIdent(node parse.Node) []string {
+	switch n := node.(type) {
+	case *parse.FieldNode:
+		return append([]string{}, n.Ident...)
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			return append([]string{}, n.Ident[1:]...)
+		}
+	}
+	return nil
+}
+
+// stringArgs returns the values of the given nodes if all of them are string
+// constants.
+func stringArgs(nodes []parse.Node) ([]string, bool) {
+	values := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		s, ok := node.(*parse.StringNode)
+		if !ok {
+			return nil, false
+		}
+		values = append(values, s.Text)
+	}
+	return values, true
+}
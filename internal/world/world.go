@@ -9,12 +9,15 @@ import (
 	"net"
 	"os"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/jmespath/go-jmespath"
 
 	"github.com/Masterminds/sprig/v3"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
 )
 
 var ErrInsecureRequired = errors.New("This feature requires the --insecure flag")
@@ -23,6 +26,54 @@ type Options struct {
 	Insecure   bool
 	LeftDelim  string
 	RightDelim string
+
+	// VaultAuth selects the method used to authenticate against Vault (e.g.
+	// approle or userpass). If empty, VAULT_AUTH_METHOD is consulted before
+	// falling back to VAULT_TOKEN.
+	VaultAuth string
+
+	// VaultAuthMount overrides the path the auth method is mounted at.
+	VaultAuthMount string
+
+	// Partials contains glob patterns of files that are parsed in addition
+	// to the main template. They can be referenced by their path.
+	Partials []string
+
+	// IncludeDirs contains directories whose files are parsed in addition
+	// to the main template. They can be referenced by their path relative to
+	// the include directory.
+	IncludeDirs []string
+
+	// Strict makes rendering fail if a template accesses a missing map key
+	// (including unset environment variables) instead of rendering an empty
+	// value or `<no value>`.
+	Strict bool
+
+	// Env contains environment variables (e.g. loaded from env files) that
+	// are layered over the process environment. They are used by the
+	// template as well as for configuring the secret backends.
+	Env map[string]string
+
+	// EnvOnly ignores the process environment so that only the variables
+	// in Env are available.
+	EnvOnly bool
+
+	// SecretCache is a directory secret values are cached in (encrypted)
+	// so that repeated renders don't have to retrieve them again. The cache
+	// is disabled if empty.
+	SecretCache string
+
+	// SecretCacheTTL defines how long values are served from SecretCache
+	// (defaults to DefaultSecretCacheTTL).
+	SecretCacheTTL time.Duration
+
+	// LogOutput replaces the output of the logger found in the context.
+	// Secret values are redacted from everything logged by the world.
+	LogOutput io.Writer
+
+	// Shell replaces the shell used by System.ShellOutput (e.g. in order to
+	// return mocked output in tests).
+	Shell func(cmd string) (string, error)
 }
 
 // New generates ... a new world ...
@@ -35,23 +86,65 @@ func New(ctx context.Context, opts *Options) *World {
 		leftDelim:  opts.LeftDelim,
 		rightDelim: opts.RightDelim,
 		insecure:   opts.Insecure,
+
+		vaultAuth:      opts.VaultAuth,
+		vaultAuthMount: opts.VaultAuthMount,
+		partials:       opts.Partials,
+		includeDirs:    opts.IncludeDirs,
+		strict:         opts.Strict,
+		extraEnv:       opts.Env,
+		envOnly:        opts.EnvOnly,
+		secretCacheDir: opts.SecretCache,
+		secretCacheTTL: opts.SecretCacheTTL,
+		shell:          opts.Shell,
+
+		secretFactories: make(map[string]func() SecretProvider),
+		secretProviders: make(map[string]SecretProvider),
+		secretCache:     make(map[string]string),
+		secretErrors:    make(map[string]error),
+		secretValues:    make(map[string]struct{}),
+		unredacted:      make(map[string]struct{}),
+		audit:           newAudit(),
+		missingEnv:      make(map[string]string),
+	}
+	w.FS.world = w
+	if opts.LogOutput != nil {
+		logger := zerolog.Ctx(ctx).Output(&redactWriter{world: w, out: opts.LogOutput})
+		w.ctx = logger.WithContext(ctx)
 	}
+	w.RegisterSecretProvider("vault", func() SecretProvider { return w.Vault() })
+	w.RegisterSecretProvider("azure", func() SecretProvider { return w.Azure() })
+	w.RegisterSecretProvider("aws", func() SecretProvider { return w.AWS() })
+	w.RegisterSecretProvider("ssm", func() SecretProvider { return awsParameters{w.AWS()} })
 	return w
 }
 
-// Env lazily loads environment variables.
+// Env lazily loads environment variables. Variables passed through
+// Options.Env take precedence over the process environment.
 func (w *World) Env() Env {
 	if w.env == nil {
 		env := Env{}
-		for _, kv := range os.Environ() {
-			elems := strings.SplitN(kv, "=", 2)
-			env[elems[0]] = elems[1]
+		if !w.envOnly {
+			for _, kv := range os.Environ() {
+				elems := strings.SplitN(kv, "=", 2)
+				env[elems[0]] = elems[1]
+			}
+		}
+		for k, v := range w.extraEnv {
+			env[k] = v
 		}
 		w.env = &env
 	}
 	return *w.env
 }
 
+// getenv works like os.Getenv but is based on the variables exposed through
+// Env.
+func (w *World) getenv(name string) string {
+	w.recordEnv(name)
+	return w.Env()[name]
+}
+
 // World acts as a container for all the knowledge we want to expose through
 // the template.
 type World struct {
@@ -60,31 +153,88 @@ type World struct {
 	env        *Env
 	vault      *Vault
 	azure      *Azure
+	aws        *AWS
 	FS         FS
 	Data       Data
 	leftDelim  string
 	rightDelim string
 	insecure   bool
+
+	vaultAuth      string
+	vaultAuthMount string
+	partials       []string
+	includeDirs    []string
+	strict         bool
+	extraEnv       map[string]string
+	envOnly        bool
+	secretCacheDir string
+	secretCacheTTL time.Duration
+	shell          func(cmd string) (string, error)
+
+	// providerMu guards the secret providers. It is held while a provider
+	// is created so that every factory is only called once even if secrets
+	// are prefetched concurrently.
+	providerMu      sync.Mutex
+	secretFactories map[string]func() SecretProvider
+	secretProviders map[string]SecretProvider
+
+	// secretMu guards the secret related state below as secrets may be
+	// prefetched concurrently. Nothing must be logged while holding it.
+	secretMu        sync.Mutex
+	secretCache     map[string]string
+	secretErrors    map[string]error
+	secretTTL       time.Duration
+	missingEnv      map[string]string
+	secretDiskCache *secretDiskCache
+	secretCacheErr  error
+
+	// redactMu guards the values to redact. It is separate from secretMu
+	// as every log line passes through Redact.
+	redactMu     sync.Mutex
+	secretValues map[string]struct{}
+	unredacted   map[string]struct{}
+
+	auditMu sync.Mutex
+	audit   *audit
 }
 
 // Render takes a template stream as input and converts the world's knowledge
 // through that template into output written to the output stream.
 func (w *World) Render(out io.Writer, in io.Reader) error {
+	return w.RenderTemplate(out, in, "")
+}
+
+// RenderTemplate works like Render but also takes the path of the template
+// which is used to resolve relative includes. If path is empty, includes are
+// resolved relative to the working directory.
+func (w *World) RenderTemplate(out io.Writer, in io.Reader, path string) error {
 	rawTmpl, err := ioutil.ReadAll(in)
 	if err != nil {
 		return errors.Wrap(err, "failed to read template")
 	}
-	tmpl, err := template.New("ROOT").Delims(w.leftDelim, w.rightDelim).Funcs(w.Funcs()).Parse(string(rawTmpl))
+	if path != "" {
+		w.recordFile(path, FileAccessTemplate)
+	}
+	set, err := w.newTemplateSet(string(rawTmpl), path)
 	if err != nil {
-		return errors.Wrap(err, "failed to parse template")
+		return err
+	}
+	w.missingEnv = make(map[string]string)
+	w.recordEnvReferences(set.tmpl)
+	w.prefetchSecrets(set.tmpl)
+	if err := set.tmpl.Execute(out, w); err != nil {
+		return w.redactError(err)
 	}
-	return tmpl.Execute(out, w)
+	return w.missingEnvError()
 }
 
 func (w *World) Funcs() template.FuncMap {
 	funcs := template.FuncMap(sprig.FuncMap())
-	funcs["vault"] = func(path, field string) (string, error) {
-		return w.Vault().Secret(path, field)
+	funcs["vault"] = func(path, field string, version ...int) (string, error) {
+		return w.Vault().Secret(path, field, version...)
+	}
+	funcs["secret"] = func(uri string) (string, error) {
+		return w.Secret(uri)
 	}
 	funcs["Azure"] = func(path string) (*Azure, error) {
 		return w.Azure(), nil
@@ -92,9 +242,18 @@ func (w *World) Funcs() template.FuncMap {
 	funcs["jsonToMap"] = func(jsonData string) (map[string]interface{}, error) {
 		return w.jsonToMap(jsonData)
 	}
-	funcs["jmsepathValue"] = func(path string, data map[string]interface{}) (interface{}, error) {
+	funcs["jmespathValue"] = func(path string, data map[string]interface{}) (interface{}, error) {
 		return jmespath.Search(path, data)
 	}
+	// jmsepathValue is the original, misspelled name of jmespathValue which
+	// is kept for compatibility.
+	funcs["jmsepathValue"] = funcs["jmespathValue"]
+	for name, fn := range w.envFuncs() {
+		funcs[name] = fn
+	}
+	if w.strict {
+		funcs["index"] = strictIndex
+	}
 	return funcs
 }
 
@@ -0,0 +1,2 @@
+environment:
+  PORT: "{{ .port }}"
\ No newline at end of file
@@ -0,0 +1,3 @@
+{{ define "healthcheck" }}healthcheck:
+  test: ["CMD", "curl", "-f", "http://localhost"]
+  interval: 10s{{ end }}
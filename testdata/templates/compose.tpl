@@ -0,0 +1,3 @@
+services:
+  web:
+{{ include "./partials/web.tpl" . | indent 4 }}
@@ -0,0 +1 @@
+nginx
\ No newline at end of file
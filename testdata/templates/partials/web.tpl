@@ -0,0 +1,2 @@
+image: {{ template "./image.tpl" }}
+{{ include "healthcheck" . }}
\ No newline at end of file